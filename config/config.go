@@ -0,0 +1,577 @@
+package config
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// Config of the server. Every setting has a default, which the config file overrides, which the environment
+// overrides, which the command-line flags override.
+type Config struct {
+	// Address the server listens on
+	Listen   string   `json:"listen"`
+	TLS      TLS      `json:"tls"`
+	Storage  Storage  `json:"storage"`
+	Database Database `json:"database"`
+	Cluster  Cluster  `json:"cluster"`
+	Auth     Auth     `json:"auth"`
+	Limits   Limits   `json:"limits"`
+	Shutdown Shutdown `json:"shutdown"`
+	Health   Health   `json:"health"`
+}
+
+// TLS the server is served with, plain http when no certificate is given. The files are reloaded when they change.
+type TLS struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Certificate authorities client certificates are verified against
+	ClientCAFile string `json:"clientCAFile"`
+	// none, request (verified when sent) or require
+	ClientAuth string `json:"clientAuth"`
+	// 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `json:"minVersion"`
+	// Cipher suites of tls 1.2 and before by their go name, the go defaults when empty
+	CipherSuites []string `json:"cipherSuites"`
+}
+
+// Storage of the chart archives and the policies applied on uploads
+type Storage struct {
+	Dir string `json:"dir"`
+	// How often the storage is polled for archives removed behind the back of helmer
+	WatchInterval   Duration `json:"watchInterval"`
+	OverwritePolicy string   `json:"overwritePolicy"`
+	LintStrictness  string   `json:"lintStrictness"`
+	DependencyMode  string   `json:"dependencyMode"`
+	// Keyring holding the public keys trusted to sign charts
+	Keyring string `json:"keyring"`
+	// File declaring the upstream repositories and the mirrors
+	Repositories string `json:"repositories"`
+}
+
+// Database the charts and releases are recorded in
+type Database struct {
+	URL string `json:"url"`
+}
+
+// Cluster the releases are deployed into
+type Cluster struct {
+	// Name matched against the cluster scopes of the role bindings
+	Name string `json:"name"`
+	// Kubeconfig used outside of a cluster, detected when empty
+	Kubeconfig string `json:"kubeconfig"`
+	// Storage driver of the helm releases: secret, configmap or memory
+	HelmDriver string `json:"helmDriver"`
+}
+
+// Auth covers authentication, authorization and the protection of browser sessions and stored secrets
+type Auth struct {
+	Enabled  bool   `json:"enabled"`
+	Htpasswd string `json:"htpasswd"`
+	// File binding roles to users and groups, every caller is an admin without one
+	RBAC string `json:"rbac"`
+	OIDC OIDC   `json:"oidc"`
+	CORS CORS   `json:"cors"`
+	CSRF bool   `json:"csrf"`
+	// id:base64 encryption keys of the stored override values, the first one encrypts
+	EncryptionKeys []string `json:"encryptionKeys"`
+}
+
+// OIDC issuer bearer jwts are accepted from
+type OIDC struct {
+	Issuer         string `json:"issuer"`
+	Audience       string `json:"audience"`
+	JWKSFile       string `json:"jwksFile"`
+	JWKSURL        string `json:"jwksUrl"`
+	UsernameClaim  string `json:"usernameClaim"`
+	UsernamePrefix string `json:"usernamePrefix"`
+	GroupsClaim    string `json:"groupsClaim"`
+}
+
+// CORS policy of cross-origin browser requests
+type CORS struct {
+	Origins     []string `json:"origins"`
+	Methods     []string `json:"methods"`
+	Headers     []string `json:"headers"`
+	Credentials bool     `json:"credentials"`
+	MaxAge      int      `json:"maxAge"`
+}
+
+// Limits on request sizes
+type Limits struct {
+	MaxUploadSize     Size `json:"maxUploadSize"`
+	MaxProvenanceSize Size `json:"maxProvenanceSize"`
+}
+
+// Shutdown on SIGTERM or SIGINT
+type Shutdown struct {
+	// How long in-flight requests and deploys are waited for before they are cut off
+	Timeout Duration `json:"timeout"`
+}
+
+// Readiness checks of /readyz
+type Health struct {
+	// How long a single check may take before it counts as failed
+	CheckTimeout Duration `json:"checkTimeout"`
+}
+
+// Duration written as a go duration like 100ms or 5m
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.Errorf("duration %s has to be a string like 100ms", data)
+	}
+
+	parsed, err := time.ParseDuration(value)
+
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+
+	return nil
+}
+
+// Size in bytes, written as a number or a quantity like 50Mi
+type Size int64
+
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(resource.NewQuantity(int64(s), resource.BinarySI).String())
+}
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var value interface{}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	return s.set(fmt.Sprint(value))
+}
+
+func (s *Size) set(value string) error {
+	quantity, err := resource.ParseQuantity(value)
+
+	if err != nil {
+		return errors.Errorf("size %s has to be a number of bytes or a quantity like 50Mi", value)
+	}
+
+	*s = Size(quantity.Value())
+
+	return nil
+}
+
+// A setting, addressed by its key in the config file which is also the name of its flag
+type setting struct {
+	Key string
+	// Environment variables, the first one set wins, the ones after the first are kept for compatibility
+	Env   []string
+	Usage string
+	value func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"listen", []string{"HELMER_LISTEN"}, "address the server listens on", func(c *Config) interface{} { return &c.Listen }},
+	{"tls.certFile", []string{"HELMER_TLS_CERT_FILE"}, "certificate served over tls", func(c *Config) interface{} { return &c.TLS.CertFile }},
+	{"tls.keyFile", []string{"HELMER_TLS_KEY_FILE"}, "private key of the certificate", func(c *Config) interface{} { return &c.TLS.KeyFile }},
+	{"tls.clientCAFile", []string{"HELMER_TLS_CLIENT_CA_FILE"}, "certificate authorities of the client certificates", func(c *Config) interface{} { return &c.TLS.ClientCAFile }},
+	{"tls.clientAuth", []string{"HELMER_TLS_CLIENT_AUTH"}, "none, request or require client certificates", func(c *Config) interface{} { return &c.TLS.ClientAuth }},
+	{"tls.minVersion", []string{"HELMER_TLS_MIN_VERSION"}, "lowest tls version accepted", func(c *Config) interface{} { return &c.TLS.MinVersion }},
+	{"tls.cipherSuites", []string{"HELMER_TLS_CIPHER_SUITES"}, "cipher suites of tls 1.2 and before", func(c *Config) interface{} { return &c.TLS.CipherSuites }},
+	{"storage.dir", []string{"HELMER_STORAGE_DIR"}, "directory the chart archives are stored in", func(c *Config) interface{} { return &c.Storage.Dir }},
+	{"storage.watchInterval", []string{"HELMER_STORAGE_WATCH_INTERVAL"}, "how often the storage is polled for removed archives", func(c *Config) interface{} { return &c.Storage.WatchInterval }},
+	{"storage.overwritePolicy", []string{"HELMER_OVERWRITE_POLICY"}, "reject, overwrite or prerelease", func(c *Config) interface{} { return &c.Storage.OverwritePolicy }},
+	{"storage.lintStrictness", []string{"HELMER_LINT_STRICTNESS"}, "off, report, error or warning", func(c *Config) interface{} { return &c.Storage.LintStrictness }},
+	{"storage.dependencyMode", []string{"HELMER_DEPENDENCY_MODE"}, "vendor, deploy or off", func(c *Config) interface{} { return &c.Storage.DependencyMode }},
+	{"storage.keyring", []string{"HELMER_KEYRING"}, "keyring of the keys trusted to sign charts", func(c *Config) interface{} { return &c.Storage.Keyring }},
+	{"storage.repositories", []string{"HELMER_REPOSITORIES"}, "file declaring the upstream repositories and mirrors", func(c *Config) interface{} { return &c.Storage.Repositories }},
+	{"database.url", []string{"HELMER_DATABASE_URL", "ELEPHANTSQL_URL"}, "postgres url of the database", func(c *Config) interface{} { return &c.Database.URL }},
+	{"cluster.name", []string{"HELMER_CLUSTER"}, "name of the cluster matched by the role bindings", func(c *Config) interface{} { return &c.Cluster.Name }},
+	{"cluster.kubeconfig", []string{"HELMER_KUBECONFIG"}, "kubeconfig used outside of a cluster", func(c *Config) interface{} { return &c.Cluster.Kubeconfig }},
+	{"cluster.helmDriver", []string{"HELMER_HELM_DRIVER", "HELM_DRIVER"}, "storage driver of the helm releases", func(c *Config) interface{} { return &c.Cluster.HelmDriver }},
+	{"auth.enabled", []string{"HELMER_AUTH"}, "require requests to be authenticated", func(c *Config) interface{} { return &c.Auth.Enabled }},
+	{"auth.htpasswd", []string{"HELMER_HTPASSWD"}, "bcrypt htpasswd file of the basic auth users", func(c *Config) interface{} { return &c.Auth.Htpasswd }},
+	{"auth.rbac", []string{"HELMER_RBAC"}, "file binding roles to users and groups", func(c *Config) interface{} { return &c.Auth.RBAC }},
+	{"auth.oidc.issuer", []string{"HELMER_OIDC_ISSUER"}, "issuer bearer jwts are accepted from", func(c *Config) interface{} { return &c.Auth.OIDC.Issuer }},
+	{"auth.oidc.audience", []string{"HELMER_OIDC_AUDIENCE"}, "audience jwts have to be issued for", func(c *Config) interface{} { return &c.Auth.OIDC.Audience }},
+	{"auth.oidc.jwksFile", []string{"HELMER_OIDC_JWKS_FILE"}, "file holding the signing keys of the issuer", func(c *Config) interface{} { return &c.Auth.OIDC.JWKSFile }},
+	{"auth.oidc.jwksUrl", []string{"HELMER_OIDC_JWKS_URL"}, "url of the signing keys of the issuer", func(c *Config) interface{} { return &c.Auth.OIDC.JWKSURL }},
+	{"auth.oidc.usernameClaim", []string{"HELMER_OIDC_USERNAME_CLAIM"}, "claim naming the caller", func(c *Config) interface{} { return &c.Auth.OIDC.UsernameClaim }},
+	{"auth.oidc.usernamePrefix", []string{"HELMER_OIDC_USERNAME_PREFIX"}, "prefix given to the names of oidc callers", func(c *Config) interface{} { return &c.Auth.OIDC.UsernamePrefix }},
+	{"auth.oidc.groupsClaim", []string{"HELMER_OIDC_GROUPS_CLAIM"}, "claim holding the groups of the caller", func(c *Config) interface{} { return &c.Auth.OIDC.GroupsClaim }},
+	{"auth.cors.origins", []string{"HELMER_CORS_ORIGINS"}, "origins allowed to make cross-origin requests", func(c *Config) interface{} { return &c.Auth.CORS.Origins }},
+	{"auth.cors.methods", []string{"HELMER_CORS_METHODS"}, "methods allowed in cross-origin requests", func(c *Config) interface{} { return &c.Auth.CORS.Methods }},
+	{"auth.cors.headers", []string{"HELMER_CORS_HEADERS"}, "headers allowed in cross-origin requests", func(c *Config) interface{} { return &c.Auth.CORS.Headers }},
+	{"auth.cors.credentials", []string{"HELMER_CORS_CREDENTIALS"}, "allow credentials in cross-origin requests", func(c *Config) interface{} { return &c.Auth.CORS.Credentials }},
+	{"auth.cors.maxAge", []string{"HELMER_CORS_MAX_AGE"}, "seconds preflight responses may be cached", func(c *Config) interface{} { return &c.Auth.CORS.MaxAge }},
+	{"auth.csrf", []string{"HELMER_CSRF"}, "require csrf tokens on browser requests", func(c *Config) interface{} { return &c.Auth.CSRF }},
+	{"auth.encryptionKeys", []string{"HELMER_ENCRYPTION_KEYS"}, "id:base64 keys encrypting the stored override values", func(c *Config) interface{} { return &c.Auth.EncryptionKeys }},
+	{"limits.maxUploadSize", []string{"HELMER_LIMITS_MAX_UPLOAD_SIZE"}, "largest chart upload", func(c *Config) interface{} { return &c.Limits.MaxUploadSize }},
+	{"limits.maxProvenanceSize", []string{"HELMER_LIMITS_MAX_PROVENANCE_SIZE"}, "largest provenance file upload", func(c *Config) interface{} { return &c.Limits.MaxProvenanceSize }},
+	{"shutdown.timeout", []string{"HELMER_SHUTDOWN_TIMEOUT"}, "how long requests and deploys are drained on shutdown", func(c *Config) interface{} { return &c.Shutdown.Timeout }},
+	{"health.checkTimeout", []string{"HELMER_HEALTH_CHECK_TIMEOUT"}, "how long a readiness check may take", func(c *Config) interface{} { return &c.Health.CheckTimeout }},
+}
+
+var (
+	mu      sync.Mutex
+	current *Config
+)
+
+var encryptionKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Defaults of every setting
+func Default() *Config {
+	return &Config{
+		Listen: ":8900",
+		TLS: TLS{
+			ClientAuth:   "none",
+			MinVersion:   "1.2",
+			CipherSuites: []string{},
+		},
+		Storage: Storage{
+			Dir:             "/tmp/charts",
+			WatchInterval:   Duration{100 * time.Millisecond},
+			OverwritePolicy: "reject",
+			LintStrictness:  "report",
+			DependencyMode:  "vendor",
+		},
+		Cluster: Cluster{HelmDriver: "secret"},
+		Auth: Auth{
+			Enabled: true,
+			CORS: CORS{
+				Origins: []string{},
+				Methods: []string{"GET", "POST", "PUT", "DELETE"},
+				Headers: []string{"Accept", "Content-Type", "Authorization", "X-CSRF-Token"},
+				MaxAge:  600,
+			},
+			CSRF:           true,
+			EncryptionKeys: []string{},
+		},
+		Limits:   Limits{MaxUploadSize: 50 << 20, MaxProvenanceSize: 1 << 20},
+		Shutdown: Shutdown{Timeout: Duration{25 * time.Second}},
+		Health:   Health{CheckTimeout: Duration{2 * time.Second}},
+	}
+}
+
+// Config in use, taken from the defaults and the environment when Load was not called
+func Get() *Config {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current == nil {
+		c := Default()
+		if err := c.applyEnv(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		current = c
+	}
+
+	return current
+}
+
+// Loads the config from the command-line arguments, the config file they or HELMER_CONFIG name and the environment.
+// The config is validated and becomes the one in use.
+func Load(args []string) (*Config, error) {
+	c := Default()
+
+	flags := flag.NewFlagSet("helmer", flag.ContinueOnError)
+
+	file := flags.String("config", os.Getenv("HELMER_CONFIG"), "yaml config file (HELMER_CONFIG)")
+
+	values := map[string]*string{}
+
+	for _, s := range settings {
+		values[s.Key] = flags.String(s.Key, "", fmt.Sprintf("%s (%s, default %s)", s.Usage, strings.Join(s.Env, ", "), s.format(Default())))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if flags.NArg() > 0 {
+		return nil, errors.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	if *file != "" {
+		data, err := ioutil.ReadFile(*file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, errors.Wrapf(err, "invalid config file %s", *file)
+		}
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.Key == f.Name && err == nil {
+				err = s.set(c, *values[s.Key])
+			}
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	current = c
+	mu.Unlock()
+
+	return c, nil
+}
+
+func (c *Config) applyEnv() error {
+	for _, s := range settings {
+		for _, env := range s.Env {
+			value, ok := os.LookupEnv(env)
+			if !ok || value == "" {
+				continue
+			}
+			if err := s.set(c, value); err != nil {
+				return errors.Wrapf(err, "invalid %s", env)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Sets a setting from its string form, lists are comma separated and booleans may also be on or off
+func (s setting) set(c *Config, value string) error {
+	var err error
+
+	switch v := s.value(c).(type) {
+	case *string:
+		*v = value
+	case *bool:
+		switch value {
+		case "on":
+			*v = true
+		case "off":
+			*v = false
+		default:
+			*v, err = strconv.ParseBool(value)
+		}
+	case *int:
+		*v, err = strconv.Atoi(value)
+	case *[]string:
+		*v = []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *Duration:
+		v.Duration, err = time.ParseDuration(value)
+	case *Size:
+		err = v.set(value)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "invalid value %q of %s", value, s.Key)
+	}
+
+	return nil
+}
+
+// String form of a setting, as shown in the usage
+func (s setting) format(c *Config) string {
+	switch v := s.value(c).(type) {
+	case *[]string:
+		return strings.Join(*v, ",")
+	case *Size:
+		return resource.NewQuantity(int64(*v), resource.BinarySI).String()
+	case *string:
+		if *v == "" {
+			return "none"
+		}
+		return *v
+	case *Duration:
+		return v.String()
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *int:
+		return strconv.Itoa(*v)
+	}
+	return ""
+}
+
+// Validates the config, every problem found is reported at once
+func (c *Config) Validate() error {
+	problems := []string{}
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Listen)
+	check(err == nil, "listen %q is not a host:port address", c.Listen)
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.certFile and tls.keyFile have to be given together")
+	checkFile := func(key string, file string) {
+		if file != "" {
+			_, err := os.Stat(file)
+			check(err == nil, "%s %s cannot be read: %v", key, file, err)
+		}
+	}
+	checkFile("tls.certFile", c.TLS.CertFile)
+	checkFile("tls.keyFile", c.TLS.KeyFile)
+	checkFile("tls.clientCAFile", c.TLS.ClientCAFile)
+	check(oneOf(c.TLS.ClientAuth, "none", "request", "require"), "tls.clientAuth %q is not none, request or require", c.TLS.ClientAuth)
+	check(c.TLS.ClientAuth == "none" || (c.TLS.CertFile != "" && c.TLS.ClientCAFile != ""), "tls.clientAuth %s needs tls.certFile and tls.clientCAFile", c.TLS.ClientAuth)
+	_, ok := TLSVersions[c.TLS.MinVersion]
+	check(ok, "tls.minVersion %q is not 1.0, 1.1, 1.2 or 1.3", c.TLS.MinVersion)
+	for _, name := range c.TLS.CipherSuites {
+		_, ok := CipherSuite(name)
+		check(ok, "tls.cipherSuites %q is not a known secure cipher suite", name)
+	}
+
+	check(c.Storage.Dir != "", "storage.dir is required")
+	check(c.Storage.WatchInterval.Duration > 0, "storage.watchInterval has to be positive")
+	check(oneOf(c.Storage.OverwritePolicy, "reject", "overwrite", "prerelease"), "storage.overwritePolicy %q is not reject, overwrite or prerelease", c.Storage.OverwritePolicy)
+	check(oneOf(c.Storage.LintStrictness, "off", "report", "error", "warning"), "storage.lintStrictness %q is not off, report, error or warning", c.Storage.LintStrictness)
+	check(oneOf(c.Storage.DependencyMode, "vendor", "deploy", "off"), "storage.dependencyMode %q is not vendor, deploy or off", c.Storage.DependencyMode)
+	checkFile("storage.keyring", c.Storage.Keyring)
+	checkFile("storage.repositories", c.Storage.Repositories)
+
+	check(c.Database.URL != "", "database.url is required")
+	if c.Database.URL != "" {
+		u, err := url.Parse(c.Database.URL)
+		check(err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql"), "database.url is not a postgres:// url")
+	}
+
+	check(oneOf(c.Cluster.HelmDriver, "secret", "secrets", "configmap", "configmaps", "memory"), "cluster.helmDriver %q is not secret, configmap or memory", c.Cluster.HelmDriver)
+	checkFile("cluster.kubeconfig", c.Cluster.Kubeconfig)
+
+	// Api tokens are created by authenticated callers, so one of the other methods has to let the first one in
+	check(!c.Auth.Enabled || c.Auth.Htpasswd != "" || c.Auth.OIDC.Issuer != "" || c.TLS.ClientAuth != "none",
+		"auth.enabled needs auth.htpasswd, auth.oidc.issuer or client certificates (tls.clientAuth), no request could authenticate otherwise")
+	checkFile("auth.htpasswd", c.Auth.Htpasswd)
+	checkFile("auth.rbac", c.Auth.RBAC)
+	checkFile("auth.oidc.jwksFile", c.Auth.OIDC.JWKSFile)
+
+	for _, origin := range c.Auth.CORS.Origins {
+		_, err := path.Match(origin, "")
+		check(err == nil, "auth.cors.origins %q is not a valid glob", origin)
+		check(origin != "*" || !c.Auth.CORS.Credentials, "auth.cors.credentials cannot be allowed for any origin, list the origins instead of *")
+	}
+	check(c.Auth.CORS.MaxAge >= 0, "auth.cors.maxAge cannot be negative")
+
+	for i, pair := range c.Auth.EncryptionKeys {
+		parts := strings.SplitN(pair, ":", 2)
+		valid := len(parts) == 2 && encryptionKeyID.MatchString(parts[0])
+		if valid {
+			key, err := base64.StdEncoding.DecodeString(parts[1])
+			valid = err == nil && len(key) == 32
+		}
+		check(valid, "auth.encryptionKeys entry %d is not an id:base64 pair of a 32 byte key", i)
+	}
+
+	check(c.Limits.MaxUploadSize > 0, "limits.maxUploadSize has to be positive")
+	check(c.Limits.MaxProvenanceSize > 0, "limits.maxProvenanceSize has to be positive")
+	check(c.Shutdown.Timeout.Duration > 0, "shutdown.timeout has to be positive")
+	check(c.Health.CheckTimeout.Duration > 0, "health.checkTimeout has to be positive")
+
+	if len(problems) > 0 {
+		return errors.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// Writes the effective config as yaml, with the database password and the encryption keys masked
+func Print(w io.Writer, c *Config) error {
+	printed := *c
+
+	if u, err := url.Parse(c.Database.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			printed.Database.URL = u.String()
+		}
+	}
+
+	printed.Auth.EncryptionKeys = []string{}
+
+	for _, pair := range c.Auth.EncryptionKeys {
+		printed.Auth.EncryptionKeys = append(printed.Auth.EncryptionKeys, strings.SplitN(pair, ":", 2)[0]+":xxxxx")
+	}
+
+	data, err := yaml.Marshal(printed)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+// Versions tls.minVersion may name
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Id of a cipher suite by its go name, insecure ones are not known
+func CipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	file, err := ioutil.TempFile("", "helmer-config-*.yaml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+
+	file.WriteString("listen: :7000\nstorage:\n  dir: /from/file\n  overwritePolicy: overwrite\n  lintStrictness: error\nauth:\n  enabled: false\n")
+	file.Close()
+
+	os.Setenv("HELMER_STORAGE_DIR", "/from/env")
+	os.Setenv("HELMER_LINT_STRICTNESS", "warning")
+	os.Setenv("ELEPHANTSQL_URL", "postgres://legacy@localhost/helmer")
+	defer os.Unsetenv("HELMER_STORAGE_DIR")
+	defer os.Unsetenv("HELMER_LINT_STRICTNESS")
+	defer os.Unsetenv("ELEPHANTSQL_URL")
+
+	c, err := Load([]string{"--config", file.Name(), "--storage.lintStrictness", "off", "--shutdown.timeout", "5s"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		key  string
+		got  interface{}
+		want interface{}
+	}{
+		{"default", c.Storage.DependencyMode, "vendor"},
+		{"file over default", c.Listen, ":7000"},
+		{"file over default", c.Storage.OverwritePolicy, "overwrite"},
+		{"env over file", c.Storage.Dir, "/from/env"},
+		{"flag over env and file", c.Storage.LintStrictness, "off"},
+		{"flag over default", c.Shutdown.Timeout.Duration, 5 * time.Second},
+		{"legacy env", c.Database.URL, "postgres://legacy@localhost/helmer"},
+	}
+
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.key, c.got, c.want)
+		}
+	}
+
+	os.Setenv("HELMER_DATABASE_URL", "postgres://current@localhost/helmer")
+	defer os.Unsetenv("HELMER_DATABASE_URL")
+
+	if c, err := Load([]string{"--auth.enabled", "off"}); err != nil || c.Database.URL != "postgres://current@localhost/helmer" {
+		t.Errorf("got database url %v and error %v, want the first environment variable to win", c, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		c := Default()
+		c.Database.URL = "postgres://localhost/helmer"
+		c.Auth.Enabled = false
+		return c
+	}
+
+	cases := []struct {
+		name    string
+		change  func(c *Config)
+		problem string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"auth without methods", func(c *Config) { c.Auth.Enabled = true }, "auth.enabled needs"},
+		{"auth with oidc", func(c *Config) { c.Auth.Enabled = true; c.Auth.OIDC.Issuer = "https://issuer.example.com" }, ""},
+		{"missing htpasswd", func(c *Config) { c.Auth.Enabled = true; c.Auth.Htpasswd = "/missing/htpasswd" }, "auth.htpasswd /missing/htpasswd cannot be read"},
+		{"client auth without ca", func(c *Config) { c.TLS.ClientAuth = "require" }, "tls.clientAuth require needs"},
+		{"database", func(c *Config) { c.Database.URL = "mysql://localhost" }, "database.url is not a postgres:// url"},
+		{"listen", func(c *Config) { c.Listen = "8900" }, "listen \"8900\" is not"},
+		{"policy", func(c *Config) { c.Storage.OverwritePolicy = "always" }, "storage.overwritePolicy \"always\""},
+		{"cors", func(c *Config) { c.Auth.CORS.Origins = []string{"*"}; c.Auth.CORS.Credentials = true }, "auth.cors.credentials cannot be allowed"},
+		{"encryption key", func(c *Config) { c.Auth.EncryptionKeys = []string{"k1:c2hvcnQ="} }, "auth.encryptionKeys entry 0"},
+	}
+
+	for _, tc := range cases {
+		c := valid()
+		tc.change(c)
+
+		err := c.Validate()
+
+		switch {
+		case tc.problem == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.problem != "" && (err == nil || !strings.Contains(err.Error(), tc.problem)):
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.problem)
+		}
+	}
+}
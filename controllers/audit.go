@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/mainak90/helmer/utils"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest page of audit entries returned as json, exports are not limited
+const maxAuditLimit = 1000
+
+// Response writer remembering the status and the start of error bodies, so failures can be recorded
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (a *auditRecorder) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditRecorder) Write(data []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	if a.status >= http.StatusBadRequest && a.body.Len() < 4096 {
+		a.body.Write(data)
+	}
+	return a.ResponseWriter.Write(data)
+}
+
+// Wraps a mutating handler so that every request is recorded in the audit log with its actor, target, redacted
+// parameters, result and duration. The handler fills in the target through utils.AuditFrom.
+func Audited(db *sql.DB, action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		identity := utils.IdentityFrom(r.Context())
+
+		params := map[string]interface{}{}
+
+		for key, value := range mux.Vars(r) {
+			params[key] = value
+		}
+
+		for key, values := range r.URL.Query() {
+			params[key] = strings.Join(values, ",")
+		}
+
+		entry := &models.AuditEntry{
+			Time:     started.Unix(),
+			Actor:    identity.Name,
+			Method:   identity.Method,
+			SourceIP: utils.SourceIP(r),
+			Action:   action,
+			Cluster:  utils.ClusterName(),
+			Params:   params,
+		}
+
+		recorder := &auditRecorder{ResponseWriter: w}
+
+		next(recorder, r.WithContext(utils.WithAudit(r.Context(), entry)))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		entry.Status = recorder.status
+		entry.Duration = time.Since(started).Milliseconds()
+		entry.Params = utils.Redact(entry.Params).(map[string]interface{})
+
+		if entry.Error == "" && recorder.status >= http.StatusBadRequest {
+			var body models.Error
+			if json.Unmarshal(recorder.body.Bytes(), &body) == nil && body.Message != "" {
+				entry.Error = body.Message
+			} else {
+				entry.Error = strings.TrimSpace(recorder.body.String())
+			}
+		}
+
+		entry.Result = utils.AuditSuccess
+
+		if entry.Error != "" {
+			entry.Result = utils.AuditFailure
+		}
+
+		utils.RecordAudit(db, *entry)
+	}
+}
+
+// Query the audit log, newest first. Supported query parameters are actor, action (release matches release.*),
+// namespace, chart, release, result, since and until (unix seconds or RFC3339) and limit (100, at most 1000).
+// With format=jsonl or an Accept of application/x-ndjson every matching entry is exported as JSON Lines.
+func GetAuditLog(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Audit Log Endpoint Hit")
+
+		if !authorize(w, r, utils.ActionAdmin, utils.Resource{}) {
+			return
+		}
+
+		query := r.URL.Query()
+
+		filter := models.AuditFilter{
+			Actor:     query.Get("actor"),
+			Action:    query.Get("action"),
+			Namespace: query.Get("namespace"),
+			Chart:     query.Get("chart"),
+			Release:   query.Get("release"),
+			Result:    query.Get("result"),
+		}
+
+		var err error
+
+		if filter.Since, err = auditTime(query.Get("since")); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if filter.Until, err = auditTime(query.Get("until")); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if limit := query.Get("limit"); limit != "" {
+			if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+				respondError(w, http.StatusBadRequest, errors.Errorf("limit %s is not a positive number", limit))
+				return
+			}
+		}
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		if query.Get("format") == "jsonl" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+			encoder := json.NewEncoder(w)
+
+			if err := chartQuery.EachAuditEntry(db, filter, func(entry models.AuditEntry) error { return encoder.Encode(entry) }); err != nil {
+				log.Printf("Audit log export failed: %-v\n", err)
+			}
+			return
+		}
+
+		if filter.Limit == 0 {
+			filter.Limit = 100
+		}
+
+		if filter.Limit > maxAuditLimit {
+			filter.Limit = maxAuditLimit
+		}
+
+		entries, err := chartQuery.GetAuditEntries(db, filter)
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, entries)
+	}
+}
+
+// Parses unix seconds or an RFC3339 time, empty is 0
+func auditTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return seconds, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return 0, errors.Errorf("%s is neither unix seconds nor an RFC3339 time", value)
+	}
+
+	return parsed.Unix(), nil
+}
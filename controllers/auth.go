@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/mainak90/helmer/utils"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Wraps a handler so that every request has to be authenticated, the identity is attached to the request context.
+// Requests go through untouched when authentication is turned off, as do the health and version endpoints.
+func Authenticate(db *sql.DB, next http.Handler) http.Handler {
+	if !utils.AuthEnabled() {
+		log.Println("Authentication is turned off, every request is served anonymously")
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, err := utils.Authenticate(db, r)
+
+		if err != nil {
+			if errors.Cause(err) != utils.ErrUnauthenticated {
+				log.Printf("Error encountered while authenticating: %-v\n", err)
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="helmer"`)
+			respondError(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(utils.WithIdentity(r.Context(), identity)))
+	})
+}
+
+// Create an api token for the caller, the token is only part of this response
+func CreateToken(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Token Creation Endpoint Hit")
+
+		var request models.TokenRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if request.Name == "" {
+			respondError(w, http.StatusBadRequest, errors.New("a token name is required"))
+			return
+		}
+
+		utils.AuditFrom(r.Context()).Params["name"] = request.Name
+		utils.AuditFrom(r.Context()).Params["expiresIn"] = request.ExpiresIn
+
+		identity := utils.IdentityFrom(r.Context())
+
+		token, err := utils.CreateToken(db, identity, request)
+
+		if err != nil {
+			log.Printf("Error encountered while creating token: %-v\n", err)
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		log.Printf("Created api token %d %s for %s\n", token.ID, token.Name, identity.Name)
+
+		respondJSON(w, http.StatusCreated, token)
+	}
+}
+
+// List the api tokens of the caller, without their secrets
+func ListTokens(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Token List Endpoint Hit")
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		tokens, err := chartQuery.GetTokens(db, utils.IdentityFrom(r.Context()).Name)
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// Revoke an api token of the caller
+func RevokeToken(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Token Revocation Endpoint Hit")
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+
+		if err != nil {
+			respondError(w, http.StatusBadRequest, errors.Errorf("invalid token id %s", mux.Vars(r)["id"]))
+			return
+		}
+
+		identity := utils.IdentityFrom(r.Context())
+
+		err = utils.RevokeToken(db, identity, id)
+
+		switch errors.Cause(err) {
+		case nil:
+		case utils.ErrTokenNotFound:
+			respondError(w, http.StatusNotFound, err)
+			return
+		default:
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		log.Printf("Revoked api token %d of %s\n", id, identity.Name)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Get the identity the caller was authenticated as
+func GetIdentity(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, utils.IdentityFrom(r.Context()))
+	}
+}
@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"database/sql"
+	"github.com/gorilla/mux"
+	"github.com/mainak90/helmer/config"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/mainak90/helmer/utils"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Delete a chart version, or every version of the chart when the version is left out of the path.
+// Charts still used by an installed release are refused unless force=true is given.
+func DeleteChart(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Chart Deletion Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		name := params["name"]
+
+		version := params["version"]
+
+		entry := utils.AuditFrom(r.Context())
+
+		entry.Chart, entry.Version = name, version
+
+		if !authorize(w, r, utils.ActionAdmin, utils.Resource{Chart: name}) {
+			return
+		}
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		if r.URL.Query().Get("force") != "true" {
+			deploys, err := chartQuery.GetChartDeploys(db, name, version)
+
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			if len(deploys) > 0 {
+				log.Printf("Refusing to delete chart %s version %s, used by %d releases\n", name, version, len(deploys))
+				respondJSON(w, http.StatusConflict, map[string]interface{}{
+					"message":  errors.Errorf("chart %s is used by %d installed releases, use force=true to delete it anyway", name, len(deploys)).Error(),
+					"releases": deploys,
+				})
+				return
+			}
+		}
+
+		charts, err := utils.DeleteCharts(db, name, version)
+
+		if err != nil {
+			log.Printf("Error encountered while deleting chart %s version %s: %-v\n", name, version, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if len(charts) == 0 {
+			respondError(w, http.StatusNotFound, errors.Errorf("chart %s version %s not found", name, version))
+			return
+		}
+
+		log.Printf("Deleted %d versions of chart %s\n", len(charts), name)
+
+		if err := utils.GenerateIndex(db); err != nil {
+			log.Printf("Failed to regenerate the repository index: %-v\n", err)
+		}
+
+		respondJSON(w, http.StatusOK, charts)
+	}
+}
+
+// Fetch the lint report stored for a chart version at upload time
+func GetChartLint(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Chart Lint Report Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{Chart: params["name"]}) {
+			return
+		}
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		report, found, err := chartQuery.GetChartLint(db, params["name"], params["version"])
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !found {
+			respondError(w, http.StatusNotFound, errors.Errorf("chart %s version %s not found", params["name"], params["version"]))
+			return
+		}
+
+		respondJSON(w, http.StatusOK, report)
+	}
+}
+
+// Upload the provenance file of a stored chart version, as multipart field provFile, and verify it against the keyring
+func UploadChartProvenance(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Chart Provenance Upload Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		entry := utils.AuditFrom(r.Context())
+
+		entry.Chart, entry.Version = params["name"], params["version"]
+
+		if !authorize(w, r, utils.ActionUpload, utils.Resource{Chart: params["name"]}) {
+			return
+		}
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		chart, found, err := chartQuery.GetChartVersion(db, params["name"], params["version"])
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !found {
+			respondError(w, http.StatusNotFound, errors.Errorf("chart %s version %s not found", params["name"], params["version"]))
+			return
+		}
+
+		if !limitBody(w, r, int64(config.Get().Limits.MaxProvenanceSize)) {
+			return
+		}
+
+		if err := r.ParseMultipartForm(int64(config.Get().Limits.MaxProvenanceSize)); err != nil && err.Error() == "http: request body too large" {
+			respondError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+
+		prov, _, err := r.FormFile("provFile")
+
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		defer prov.Close()
+
+		chart, err = utils.StoreProvenance(db, chart, prov)
+
+		if err != nil {
+			log.Printf("Error encountered while storing provenance file: %-v\n", err)
+			if errors.Cause(err) == utils.ErrProvenanceRejected {
+				respondError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, chart)
+	}
+}
+
+// Fetch the details of a chart version: Chart.yaml metadata, dependencies, default values, values schema, README and templates
+func GetChartDetail(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Chart Detail Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{Chart: params["name"]}) {
+			return
+		}
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		chart, found, err := chartQuery.GetChartVersion(db, params["name"], params["version"])
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !found {
+			respondError(w, http.StatusNotFound, errors.Errorf("chart %s version %s not found", params["name"], params["version"]))
+			return
+		}
+
+		detail, err := utils.GetChartDetail(chart)
+
+		if err != nil {
+			log.Printf("Error encountered while loading chart %s version %s: %-v\n", chart.Name, chart.Version, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, detail)
+	}
+}
+
+// List every stored version of a chart, newest semver first
+func ListChartVersions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Chart Versions Endpoint Hit")
+
+		name := mux.Vars(r)["name"]
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{Chart: name}) {
+			return
+		}
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		charts, err := chartQuery.GetChartVersions(db, name)
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if len(charts) == 0 {
+			respondError(w, http.StatusNotFound, errors.Errorf("chart %s not found", name))
+			return
+		}
+
+		utils.SortChartVersions(charts)
+
+		respondJSON(w, http.StatusOK, charts)
+	}
+}
+
+// Search the chart catalog.
+// Supported query parameters are q (text), version (semver constraint), appVersion, type, maintainer,
+// prerelease=true, latest=true, page and pageSize.
+func SearchCharts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Chart Search Endpoint Hit")
+
+		query := r.URL.Query()
+
+		opts := utils.SearchOptions{
+			Text:       query.Get("q"),
+			Constraint: query.Get("version"),
+			AppVersion: query.Get("appVersion"),
+			Type:       query.Get("type"),
+			Maintainer: query.Get("maintainer"),
+			Prerelease: query.Get("prerelease") == "true",
+			Latest:     query.Get("latest") == "true",
+			Allow: func(name string) bool {
+				return canRead(r, utils.Resource{Chart: name})
+			},
+		}
+
+		for param, target := range map[string]*int{"page": &opts.Page, "pageSize": &opts.PageSize} {
+			if value := query.Get(param); value != "" {
+				parsed, err := strconv.Atoi(value)
+				if err != nil || parsed < 1 {
+					respondError(w, http.StatusBadRequest, errors.Errorf("invalid %s %q, it has to be a positive number", param, value))
+					return
+				}
+				*target = parsed
+			}
+		}
+
+		search, err := utils.SearchCharts(db, opts)
+
+		if err != nil {
+			log.Printf("Error encountered while searching charts: %-v\n", err)
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, search)
+	}
+}
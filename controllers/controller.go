@@ -3,93 +3,190 @@ package controllers
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/mainak90/helmer/config"
 	"github.com/mainak90/helmer/models"
 	chartQueries "github.com/mainak90/helmer/queries/chart"
 	"github.com/mainak90/helmer/utils"
+	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/strvals"
-	"io"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
 // Self explanatory, does multi-part upload of helm archives with the associated index.html
+// Re-uploading an identical archive is a no-op, different contents for an existing version are subject to
+// the overwrite policy (HELMER_OVERWRITE_POLICY: reject, overwrite or prerelease) and refused with 409.
 func UploadHelmChart(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("File Upload Endpoint Hit")
 
-		if r.Method != "POST" {
-			log.Println("This endpoint only supports POST request!")
+		if !authorize(w, r, utils.ActionUpload, utils.Resource{}) {
 			return
 		}
 
-		r.ParseMultipartForm(50 << 20)
-
-		file, handler, err := r.FormFile("myFile")
+		if !limitBody(w, r, int64(config.Get().Limits.MaxUploadSize)) {
+			return
+		}
 
-		if filepath.Ext(handler.Filename) != ".tgz" {
-			log.Println("Error encountered! The provided file extension is not .tgz")
+		if err := r.ParseMultipartForm(int64(config.Get().Limits.MaxUploadSize)); err != nil && err.Error() == "http: request body too large" {
+			respondError(w, http.StatusRequestEntityTooLarge, err)
 			return
 		}
 
+		file, handler, err := r.FormFile("myFile")
+
 		if err != nil {
-			log.Println("Error Retrieving the File")
-			log.Fatal(err)
+			log.Printf("Error Retrieving the File: %-v\n", err)
+			respondError(w, http.StatusBadRequest, err)
 			return
 		}
 
 		defer file.Close()
 
+		if filepath.Ext(handler.Filename) != ".tgz" {
+			log.Println("Error encountered! The provided file extension is not .tgz")
+			respondError(w, http.StatusBadRequest, errors.Errorf("the provided file %s is not a .tgz archive", handler.Filename))
+			return
+		}
+
 		log.Printf("Uploaded File: %+v\n", handler.Filename)
 
 		log.Printf("File Size: %+v\n", handler.Size)
 
 		log.Printf("MIME Header: %+v\n", handler.Header)
 
-		// The assumption is that the filename will always follow the format <app>-<version>.tgz
-		// the app seperators should be '_' and not '-'
-		withoutExt := strings.Split(handler.Filename, ".tgz")
+		entry := utils.AuditFrom(r.Context())
 
-		split := strings.Split(withoutExt[0], "-")
+		entry.Params["file"], entry.Params["size"] = handler.Filename, handler.Size
 
-		chartName := split[0]
+		// Name and version are read from the Chart.yaml of the archive, not from the filename
+		result, err := utils.StoreChartWith(db, file, utils.StoreOptions{Allow: uploadAllowed(r)})
 
-		version := split[1]
+		entry.Chart, entry.Version = result.Chart.Name, result.Chart.Version
 
-		// Creates the directories if it doesn't exist
-		os.MkdirAll("/tmp/charts/"+chartName+"/"+version, os.ModePerm)
+		if !storeSucceeded(w, result, err) {
+			return
+		}
 
-		// Creates the file descriptor
-		f, err := os.OpenFile("/tmp/charts/"+chartName+"/"+version+"/"+handler.Filename, os.O_WRONLY|os.O_CREATE, 0666)
+		log.Printf("File uploaded successfully: %+v\n", result.Chart.Name)
 
-		if err != nil {
-			log.Fatal(err)
+		log.Printf("Chart Version: %+v\n", result.Chart.Version)
+
+		// The provenance file is optional, charts uploaded without one are recorded as unsigned
+		if prov, _, err := r.FormFile("provFile"); err == nil {
+			defer prov.Close()
+
+			result.Chart, err = utils.StoreProvenance(db, result.Chart, prov)
+
+			if err != nil {
+				log.Printf("Error encountered while storing provenance file: %-v\n", err)
+				if errors.Cause(err) == utils.ErrProvenanceRejected {
+					respondError(w, http.StatusUnprocessableEntity, err)
+					return
+				}
+				respondError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		respondStored(w, db, result)
+	}
+}
+
+// Import a chart from a git repository, the chart is packaged and stored the way uploads are
+func ImportGitChart(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Git Chart Import Endpoint Hit")
+
+		if !authorize(w, r, utils.ActionUpload, utils.Resource{}) {
 			return
 		}
 
-		defer f.Close()
+		var source models.GitImport
 
-		io.Copy(f, file)
+		if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
 
-		log.Printf("File uploaded successfully: %+v\n", chartName)
+		entry := utils.AuditFrom(r.Context())
 
-		log.Printf("Chart Version: %+v\n", version)
+		entry.Params["url"], entry.Params["ref"], entry.Params["path"] = source.URL, source.Ref, source.Path
+		entry.Params["version"], entry.Params["appVersion"] = source.Version, source.AppVersion
 
-		charted := models.Chart{Name: chartName, Version: version, Path: "/tmp/charts/" + chartName + "/" + version + "/" + handler.Filename}
+		result, err := utils.ImportGitChart(db, source, utils.StoreOptions{Allow: uploadAllowed(r)})
 
-		// Call the sql query struct
-		chartQuery := chartQueries.ChartQueries{}
+		entry.Chart, entry.Version = result.Chart.Name, result.Chart.Version
+
+		if !storeSucceeded(w, result, err) {
+			return
+		}
+
+		log.Printf("Imported chart %s version %s from %s\n", result.Chart.Name, result.Chart.Version, source.URL)
+
+		respondStored(w, db, result)
+	}
+}
+
+// Responds with the error of storing a chart archive, returns whether storing succeeded
+func storeSucceeded(w http.ResponseWriter, result utils.StoreResult, err error) bool {
+	switch errors.Cause(err) {
+	case nil:
+		return true
+	case utils.ErrInvalidChart:
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusBadRequest, err)
+	case utils.ErrLintFailed:
+		log.Printf("Error encountered: %-v\n", err)
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"message": err.Error(),
+			"lint":    result.Lint,
+		})
+	case utils.ErrChartExists:
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusConflict, err)
+	case utils.ErrForbidden:
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusForbidden, err)
+	case utils.ErrGitImport:
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusUnprocessableEntity, err)
+	case utils.ErrUnresolvedDependencies:
+		log.Printf("Error encountered: %-v\n", err)
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":      err.Error(),
+			"dependencies": result.Dependencies,
+		})
+	default:
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusInternalServerError, err)
+	}
+	return false
+}
+
+// Responds with a stored chart, 201 when the version is new, and regenerates the index when anything changed
+func respondStored(w http.ResponseWriter, db *sql.DB, result utils.StoreResult) {
+	if result.Unchanged {
+		respondJSON(w, http.StatusOK, result.Chart)
+		return
+	}
+
+	if err := utils.GenerateIndex(db); err != nil {
+		log.Printf("Failed to regenerate the repository index: %-v\n", err)
+	}
 
-		// Add the chart into the sql table
-		chartQuery.AddChart(db, charted)
+	if result.Created {
+		respondJSON(w, http.StatusCreated, result.Chart)
+		return
 	}
+
+	respondJSON(w, http.StatusOK, result.Chart)
 }
 
 // List helm charts from postgresql
@@ -110,18 +207,25 @@ func ListHelmCharts(db *sql.DB) http.HandlerFunc {
 
 		charts = chartQuery.GetCharts(db, chart, charts)
 
-		json.NewEncoder(w).Encode(charts)
+		readable := []models.Chart{}
+
+		for _, c := range charts {
+			if canRead(r, utils.Resource{Chart: c.Name}) {
+				readable = append(readable, c)
+			}
+		}
+
+		json.NewEncoder(w).Encode(readable)
 	}
 }
 
 // Deploy the release/chart into the associated kubernetes cluster
-//{"name":"redis","chart":"redis-","version":"0.5.7","namespace": "default","vars": ["mysqlRootPassword=admin@123,imagePullPolicy=IfNotPresent"]}
+//{"name":"mysql","chart":"mysql","version":"0.5.7","namespace": "default","vars": ["imagePullPolicy=IfNotPresent"],"valuesFrom": [{"secret": "mysql-credentials", "key": "password", "path": "mysqlRootPassword"}]}
+// The version may also be "latest" or a semver constraint like "~0.5", the response carries the resolved version.
 func DeployApp(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Endpoint hit!")
-		var deploys int
 		var deploy models.Deploy
-		var chart models.Chart
 
 		err := json.NewDecoder(r.Body).Decode(&deploy)
 
@@ -141,92 +245,224 @@ func DeployApp(db *sql.DB) http.HandlerFunc {
 		log.Printf("Name is %+v\n", name)
 		log.Printf("Version is %+v\n", version)
 
-		chartQuery := chartQueries.ChartQueries{}
+		entry := utils.AuditFrom(r.Context())
 
-		chartPath := chartQuery.GetChartPath(db, chart, name, version)
+		entry.Namespace, entry.Chart, entry.Version, entry.Release = namespace, name, version, deploy.Name
+		entry.Params["vars"] = utils.RedactSetValues(deploy.Vars)
+		entry.Params["verify"], entry.Params["prerelease"] = deploy.Verify, deploy.Prerelease
 
-		log.Printf("Chart path %+v\n", chartPath)
-		// Chart path sends NotFound incase chart is not found, loop exexution to stop in such case.
-		if chartPath == "Notfound" {
-			log.Printf("No chart path is retrieved from the provided data, cannot proceed...")
+		// The namespace scopes of the caller are checked against it, helm would fall back to the default namespace
+		if namespace == "" {
+			respondError(w, http.StatusBadRequest, errors.New("a namespace is required"))
 			return
 		}
 
-		log.Printf("Deploying chart %-s version %-v into namespace %-v\n", name, version, namespace)
+		if !authorize(w, r, utils.ActionDeploy, utils.Resource{Namespace: namespace, Chart: name, Namespaced: true}) {
+			return
+		}
 
-		// Rertieve client config
-		actionConfig, err := utils.GetActionConfig(namespace)
+		// The version may be exact, latest or a semver constraint, the release is recorded with the resolved one
+		chart, err := utils.ResolveChartVersion(db, name, version, deploy.Prerelease)
 
 		if err != nil {
-			panic(err)
+			log.Printf("No chart is retrieved from the provided data, cannot proceed: %-v\n", err)
+			if errors.Cause(err) == utils.ErrChartNotFound {
+				respondError(w, http.StatusNotFound, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
 		}
 
+		if chart.Version != version {
+			log.Printf("Resolved version %s of chart %s to %s\n", version, name, chart.Version)
+			deploy.Requested = version
+			deploy.Version = chart.Version
+			entry.Version = chart.Version
+		}
 
-		iCli := action.NewInstall(actionConfig)
+		deployRelease(w, db, entry, deploy, chart, false)
+	}
+}
 
-		iCli.ReleaseName = deploy.Name
+// Installs, or upgrades, a release of a resolved chart version with the override values of the deploy, records the
+// deploy as pending first and answers with its outcome.
+func deployRelease(w http.ResponseWriter, db *sql.DB, entry *models.AuditEntry, deploy models.Deploy, chart models.Chart, upgrade bool) {
+	name, version, namespace := deploy.Chart, deploy.Version, deploy.Namespace
 
-		// Load chart..
-		charted, err := loader.Load(chartPath)
+	chartQuery := chartQueries.ChartQueries{}
 
-		if err != nil {
-			log.Printf("Error encountered: %-v\n", err)
+	chartPath := chart.Path
+
+	log.Printf("Chart path %+v\n", chartPath)
+
+	// Refuse charts which are unsigned or signed by a key missing from the keyring when asked to
+	if deploy.Verify {
+		status, signer, _ := utils.VerifyChart(chartPath)
+
+		if status != utils.ProvenanceVerified {
+			log.Printf("Refusing to deploy chart %s version %s, provenance is %s\n", name, version, status)
+			respondError(w, http.StatusUnprocessableEntity, errors.Wrapf(utils.ErrUnverifiedChart, "chart %s version %s provenance is %s", name, version, status))
 			return
 		}
 
-		// Check if chart is valid before installing..
-		validInstallableChart, err := utils.IsChartInstallable(charted)
+		log.Printf("Chart %s version %s is signed by %s\n", name, version, signer)
+	}
+
+	log.Printf("Deploying chart %-s version %-v into namespace %-v\n", name, version, namespace)
 
-		// Stop here if chart is not valid..
-		if !validInstallableChart {
+	// Rertieve client config
+	actionConfig, err := utils.GetActionConfig(namespace)
+
+	if err != nil {
+		log.Printf("Error encountered while building the cluster client: %-v\n", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+
+	// Load chart..
+	charted, err := loader.Load(chartPath)
+
+	if err != nil {
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Dependencies left out of the archive are resolved again on every deploy
+	if utils.DependencyMode() != utils.DependencyOff {
+		if _, err := utils.ResolveDependencies(db, charted); err != nil {
 			log.Printf("Error encountered: %-v\n", err)
+			respondError(w, http.StatusUnprocessableEntity, err)
 			return
 		}
+	}
 
-		// The way i see online(due to lack of proper documentation) ,
-		vals := make(map[string]interface{})
-		for _, value := range deploy.Vars {
-			if err := strvals.ParseInto(value, vals); err != nil {
-				fmt.Errorf("failed parsing set data")
-				return
-			}
+	// Check if chart is valid before installing..
+	validInstallableChart, err := utils.IsChartInstallable(charted)
+
+	// Stop here if chart is not valid..
+	if !validInstallableChart {
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// The way i see online(due to lack of proper documentation) ,
+	vals := make(map[string]interface{})
+	for _, value := range deploy.Vars {
+		if err := strvals.ParseInto(value, vals); err != nil {
+			log.Printf("Failed parsing set data: %-v\n", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		log.Printf("Loaded chart %-s with version %-v\n", name, version,)
-		log.Printf("Loaded chart values as --set : %s\n", vals)
-		log.Printf("Trying to deploy chart %-s version %-v into namespace %-v\n", name, version, namespace)
+	}
 
-		rel, err := iCli.Run(charted, vals)
-		if err != nil {
-			log.Printf("Error encountered : %-v\n",err)
-			deploy.Status = "Failed"
-			deploy.Time = time.Now().Unix()
-			deploys, err = chartQuery.AddDeploy(db, deploy)
+	// Values marked secret by the values schema, and those read from kubernetes secrets, are kept out of logs,
+	// responses and the database like sensitive keys
+	secrets := utils.SchemaSecrets(charted.Schema)
 
-			if err != nil {
-				log.Printf("Failed to add row into table deployment for chart %-s version %-v for namespace %s\n", name, version, namespace)
-			}
+	vals, err = utils.ResolveSecretValues(actionConfig, namespace, deploy.ValuesFrom, vals, secrets)
 
-			json.NewEncoder(w).Encode(deploys)
+	if err != nil {
+		log.Printf("Error encountered: %-v\n", err)
+		if errors.Cause(err) == utils.ErrSecretValue {
+			respondError(w, http.StatusUnprocessableEntity, err)
 			return
 		}
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
 
-		log.Printf("Successfully installed chart-release : %-v\n", rel.Name)
-		deploy.Status = "Success"
-		deploy.Time = time.Now().Unix()
-		// Add record as deployed chart into the database.
-		log.Printf("Trying to add entry into database")
+	values, err := utils.SealValues(deploy.Vars, secrets)
 
-		deploys, err = chartQuery.AddDeploy(db, deploy)
+	if err != nil {
+		log.Printf("Error encountered while sealing override values: %-v\n", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
 
-		if err != nil {
-			log.Printf("Failed to add row into table deployment for chart %s version %s for namespace %s\n", name, version, namespace)
-			return
+	deploy.Vars = utils.RedactSetValuesWith(deploy.Vars, secrets)
+	entry.Params["vars"] = deploy.Vars
+	entry.Params["valuesFrom"] = deploy.ValuesFrom
+
+	log.Printf("Loaded chart %-s with version %-v\n", name, version,)
+	log.Printf("Loaded chart values as --set : %s\n", utils.RedactValues(vals, secrets))
+	log.Printf("Trying to deploy chart %-s version %-v into namespace %-v\n", name, version, namespace)
+
+	// The deploy is recorded as pending first, a shutdown cutting the install off marks it interrupted
+	operation := "install"
+
+	if upgrade {
+		operation = "upgrade"
+	}
+
+	job, err := utils.StartJob(operation + " of release " + deploy.Name + " in namespace " + namespace)
+
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	defer job.Done()
+
+	deploy.Status = utils.DeployPending
+	deploy.Instance = utils.Instance
+	deploy.Time = time.Now().Unix()
+
+	deploys, err := chartQuery.AddDeploy(db, deploy, values)
+
+	if err != nil {
+		log.Printf("Failed to add row into table deployment for chart %s version %s for namespace %s\n", name, version, namespace)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	job.Record(deploys)
+
+	deploy.ID = deploys
+
+	var rel *release.Release
+
+	if upgrade {
+		uCli := action.NewUpgrade(actionConfig)
+		uCli.Namespace = namespace
+		rel, err = uCli.Run(deploy.Name, charted, vals)
+	} else {
+		iCli := action.NewInstall(actionConfig)
+		iCli.ReleaseName = deploy.Name
+		rel, err = iCli.Run(charted, vals)
+	}
+
+	if err != nil {
+		log.Printf("Error encountered : %-v\n",err)
+		entry.Error = err.Error()
+		deploy.Status = utils.DeployFailed
+		deploy.Time = time.Now().Unix()
+
+		if err := chartQuery.UpdateDeployState(db, deploy.ID, deploy.Status, deploy.Time); err != nil {
+			log.Printf("Failed to update row of table deployment for chart %-s version %-v for namespace %s\n", name, version, namespace)
 		}
 
-		log.Printf("Added row into table deployment for chart %s version %s for namespace %s\n", name, version, namespace)
+		json.NewEncoder(w).Encode(deploy)
+		return
+	}
 
-		json.NewEncoder(w).Encode(deploys)
+	log.Printf("Successfully %sed chart-release : %-v\n", operation, rel.Name)
+	deploy.Status = utils.DeploySuccess
+	deploy.Time = time.Now().Unix()
+	// Record the deployed chart into the database.
+	log.Printf("Trying to update entry in database")
+
+	if err := chartQuery.UpdateDeployState(db, deploy.ID, deploy.Status, deploy.Time); err != nil {
+		log.Printf("Failed to update row of table deployment for chart %s version %s for namespace %s\n", name, version, namespace)
+		return
 	}
+
+	log.Printf("Updated row of table deployment for chart %s version %s for namespace %s\n", name, version, namespace)
+
+	json.NewEncoder(w).Encode(deploy)
 }
 
 func ListDeployments(db *sql.DB) http.HandlerFunc {
@@ -246,63 +482,112 @@ func ListDeployments(db *sql.DB) http.HandlerFunc {
 
 		deploys = chartQuery.GetDeploys(db, deploy, deploys)
 
-		json.NewEncoder(w).Encode(deploys)
+		readable := []models.Deploy{}
+
+		for _, d := range deploys {
+			if canRead(r, utils.Resource{Namespace: d.Namespace, Chart: d.Chart, Namespaced: true}) {
+				readable = append(readable, d)
+			}
+		}
+
+		json.NewEncoder(w).Encode(readable)
 	}
 }
 
+// Uninstall the release from the cluster and mark its records as uninstalled in the database.
+// Supported query parameters are keepHistory, disableHooks, purge (drop the records instead) and timeout (duration like 5m).
 func DeleteDeployment(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		log.Println("Helm Deployment Deletion Endpoint Hit")
 
-		if r.Method != "POST" {
-			log.Println("This endpoint only supports POST request!")
-			return
-		}
-
 		params := mux.Vars(r)
 
 		releaseName := params["name"]
 
 		namespace := params["namespace"]
 
-		if utils.IsInstalled(namespace, releaseName) {
-			log.Printf("Release %s is  installed in namespace %s proceed to undeploy \n", releaseName, namespace)
-		} else {
-			log.Printf("Release %s is not installed in namespace %s \n", releaseName, namespace)
+		query := r.URL.Query()
+
+		actionConfig, rel, ok := lastRelease(w, r, utils.ActionDeploy, namespace, releaseName)
+
+		if !ok {
 			return
 		}
 
+		switch {
+		case rel.Info.Status == release.StatusUninstalled:
+			respondError(w, http.StatusConflict, errors.Errorf("release %s is already uninstalled from namespace %s", releaseName, namespace))
+			return
+		case rel.Info.Status == release.StatusPendingInstall, rel.Info.Status == release.StatusPendingUpgrade,
+			rel.Info.Status == release.StatusPendingRollback, rel.Info.Status == release.StatusUninstalling:
+			respondError(w, http.StatusConflict, errors.Errorf("release %s has an operation in progress: %s", releaseName, rel.Info.Status))
+			return
+		}
 
-		actionConfig, err := utils.GetActionConfig(namespace)
+		log.Printf("Release %s is installed in namespace %s proceed to undeploy \n", releaseName, namespace)
+
+		iCli := action.NewUninstall(actionConfig)
+
+		iCli.KeepHistory = query.Get("keepHistory") == "true"
+
+		iCli.DisableHooks = query.Get("disableHooks") == "true"
+
+		iCli.Timeout = 5 * time.Minute
+
+		if timeout := query.Get("timeout"); timeout != "" {
+			parsed, err := time.ParseDuration(timeout)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			iCli.Timeout = parsed
+		}
+
+		job, err := utils.StartJob("uninstall of release " + releaseName + " in namespace " + namespace)
 
 		if err != nil {
-			panic(err)
+			respondError(w, http.StatusServiceUnavailable, err)
+			return
 		}
 
-		iCli := action.NewUninstall(actionConfig)
+		defer job.Done()
 
-		rel, err := iCli.Run(releaseName)
+		res, err := iCli.Run(releaseName)
 
 		if err != nil {
-			log.Printf("Error encountered : %-v\n",err)
+			log.Printf("Error encountered : %-v\n", err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
 		}
 
-		log.Printf("Successfully uninstalled chart-release : %-v\n", rel.Release.Name)
+		log.Printf("Successfully uninstalled chart-release : %-v\n", res.Release.Name)
 
 		chartQuery := chartQueries.ChartQueries{}
 
-		log.Printf("Removing record for chart release: %-v from database table \n", rel.Release.Name)
+		var rows int64
 
-		rowdel, err := chartQuery.RemoveDeployment(db, releaseName)
+		if query.Get("purge") == "true" {
+			log.Printf("Removing records for chart release: %-v from database table \n", releaseName)
+			rows, err = chartQuery.RemoveDeployment(db, releaseName, namespace)
+		} else {
+			log.Printf("Marking records for chart release: %-v as uninstalled in database table \n", releaseName)
+			rows, err = chartQuery.MarkDeploymentDeleted(db, releaseName, namespace, time.Now().Unix())
+		}
 
 		if err != nil {
 			log.Printf("Error encountered %-v\n", err)
+			respondError(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		log.Printf("Removed record for release %-v in namespace %-s from database .. \n", rel.Release.Name, rel.Release.Namespace)
-
-		json.NewEncoder(w).Encode(rowdel)
+		respondJSON(w, http.StatusOK, models.Uninstall{
+			Name:        releaseName,
+			Namespace:   namespace,
+			Status:      release.StatusUninstalled.String(),
+			Info:        res.Info,
+			KeepHistory: iCli.KeepHistory,
+			Records:     rows,
+		})
 	}
-}
\ No newline at end of file
+}
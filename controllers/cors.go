@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/utils"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Answers preflight requests and adds the cors headers for the origins the policy allows. Requests from other
+// origins get no cors headers, so browsers keep their responses from the page that made them.
+func CORS(policy utils.CORSPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		if origin == "" || utils.SameOrigin(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !policy.AllowsOrigin(origin) {
+			if preflight {
+				respondError(w, http.StatusForbidden, errors.Errorf("origin %s is not allowed", origin))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if policy.Credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+
+		if !policy.AllowsMethod(method) || !policy.AllowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+			respondError(w, http.StatusForbidden, errors.Errorf("method %s or the requested headers are not allowed", method))
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Protects browser sessions against cross-site request forgery. Every response hands out a csrf cookie when the
+// request has none, the built-in UI sends it back as the csrf_token form field or the X-CSRF-Token header. Unsafe
+// requests a browser makes on its own, recognized by their Origin, Sec-Fetch-Site or Cookie header, have to carry
+// it unless they come from an origin the cors policy allows. Bearer tokens are never sent by browsers on their own
+// and are left alone, as are requests from other clients.
+func CSRF(policy utils.CORSPolicy, next http.Handler) http.Handler {
+	if !utils.CSRFEnabled() {
+		log.Println("CSRF protection is turned off")
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(utils.CSRFCookie); err != nil {
+			if token, err := utils.NewCSRFToken(); err == nil {
+				http.SetCookie(w, &http.Cookie{
+					Name:     utils.CSRFCookie,
+					Value:    token,
+					Path:     "/",
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+		}
+
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fromBrowser := r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != "" || r.Header.Get("Cookie") != ""
+
+		if !fromBrowser || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && !utils.SameOrigin(r) {
+			if !policy.AllowsOrigin(origin) {
+				log.Printf("Refused %s %s from origin %s\n", r.Method, r.URL.Path, origin)
+				respondError(w, http.StatusForbidden, errors.Wrapf(utils.ErrCSRF, "origin %s is not allowed", origin))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The token may be a form field, the form is only read up to the upload limit
+		if r.Header.Get(utils.CSRFHeader) == "" && !limitBody(w, r, int64(config.Get().Limits.MaxUploadSize)) {
+			return
+		}
+
+		if err := utils.CheckCSRF(r); err != nil {
+			log.Printf("Refused %s %s: %-v\n", r.Method, r.URL.Path, err)
+			respondError(w, http.StatusForbidden, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
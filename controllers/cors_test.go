@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"github.com/mainak90/helmer/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var testPolicy = utils.CORSPolicy{
+	Origins: []string{"https://ui.example.com"},
+	Methods: []string{"GET", "POST", "PUT", "DELETE"},
+	Headers: []string{"Content-Type", "Authorization", "X-CSRF-Token"},
+	MaxAge:  600,
+}
+
+// Handler answering 200 and telling whether it was reached
+func reached(called *bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*called = true
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestCORS(t *testing.T) {
+	cases := []struct {
+		name      string
+		method    string
+		origin    string
+		preflight string
+		headers   string
+		status    int
+		allowed   bool
+		reached   bool
+	}{
+		{"no origin", "GET", "", "", "", http.StatusOK, false, true},
+		{"same origin", "POST", "http://helmer.example.com", "", "", http.StatusOK, false, true},
+		{"allowed origin", "GET", "https://ui.example.com", "", "", http.StatusOK, true, true},
+		{"foreign origin", "GET", "https://evil.com", "", "", http.StatusOK, false, true},
+		{"preflight of a foreign origin", "OPTIONS", "https://evil.com", "DELETE", "", http.StatusForbidden, false, false},
+		{"preflight", "OPTIONS", "https://ui.example.com", "DELETE", "Content-Type, X-CSRF-Token", http.StatusNoContent, true, false},
+		{"preflight of a method not allowed", "OPTIONS", "https://ui.example.com", "PATCH", "", http.StatusForbidden, true, false},
+		{"preflight of a header not allowed", "OPTIONS", "https://ui.example.com", "PUT", "X-Custom", http.StatusForbidden, true, false},
+	}
+
+	for _, c := range cases {
+		var called bool
+
+		r := httptest.NewRequest(c.method, "http://helmer.example.com/charts", nil)
+
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+
+		if c.preflight != "" {
+			r.Header.Set("Access-Control-Request-Method", c.preflight)
+			r.Header.Set("Access-Control-Request-Headers", c.headers)
+		}
+
+		w := httptest.NewRecorder()
+
+		CORS(testPolicy, reached(&called)).ServeHTTP(w, r)
+
+		if w.Code != c.status || called != c.reached {
+			t.Errorf("%s: got status %d and reached %t, want %d and %t", c.name, w.Code, called, c.status, c.reached)
+		}
+
+		if allowed := w.Header().Get("Access-Control-Allow-Origin") != ""; allowed != c.allowed {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q", c.name, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+
+		if c.status == http.StatusNoContent && w.Header().Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: got Access-Control-Max-Age %q", c.name, w.Header().Get("Access-Control-Max-Age"))
+		}
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		cookie  string
+		form    string
+		reached bool
+	}{
+		{"safe method", "GET", map[string]string{"Origin": "https://evil.com"}, "", "", true},
+		{"client other than a browser", "POST", nil, "", "", true},
+		{"bearer token", "POST", map[string]string{"Authorization": "Bearer hlm_abc", "Origin": "https://evil.com"}, "abc", "", true},
+		{"foreign origin", "POST", map[string]string{"Origin": "https://evil.com"}, "abc", "", false},
+		{"origin the cors policy allows", "DELETE", map[string]string{"Origin": "https://ui.example.com"}, "", "", true},
+		{"same origin without token", "POST", map[string]string{"Origin": "http://helmer.example.com"}, "abc", "", false},
+		{"cookie without token", "POST", nil, "abc", "", false},
+		{"fetch metadata without cookie", "POST", map[string]string{"Sec-Fetch-Site": "same-origin", "X-CSRF-Token": "abc"}, "", "", false},
+		{"token header", "POST", map[string]string{"X-CSRF-Token": "abc"}, "abc", "", true},
+		{"wrong token header", "POST", map[string]string{"X-CSRF-Token": "abd"}, "abc", "", false},
+		{"token form field", "POST", nil, "abc", "abc", true},
+	}
+
+	for _, c := range cases {
+		var called bool
+
+		form := url.Values{}
+
+		if c.form != "" {
+			form.Set(utils.CSRFField, c.form)
+		}
+
+		r := httptest.NewRequest(c.method, "http://helmer.example.com/deployChart", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		for name, value := range c.headers {
+			r.Header.Set(name, value)
+		}
+
+		if c.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: utils.CSRFCookie, Value: c.cookie})
+		}
+
+		w := httptest.NewRecorder()
+
+		CSRF(testPolicy, reached(&called)).ServeHTTP(w, r)
+
+		if called != c.reached {
+			t.Errorf("%s: got status %d, want reached %t", c.name, w.Code, c.reached)
+		}
+
+		if !c.reached && w.Code != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want 403", c.name, w.Code)
+		}
+
+		if handedOut := strings.Contains(w.Header().Get("Set-Cookie"), utils.CSRFCookie+"="); handedOut != (c.cookie == "") {
+			t.Errorf("%s: got Set-Cookie %q", c.name, w.Header().Get("Set-Cookie"))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"database/sql"
+	"github.com/mainak90/helmer/utils"
+	"log"
+	"net/http"
+)
+
+// Paths answered without credentials, the probes of kubernetes send none
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/version": true,
+}
+
+// Liveness of the process, ok as long as it serves requests
+func GetHealth(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, map[string]string{"status": utils.HealthOK})
+	}
+}
+
+// Readiness to serve, 503 with the failed checks unless the database, the storage, the watcher and the cluster are
+// all fine. Probes are not logged, they come every few seconds, failed checks are. Callers without credentials only
+// get the name and status of the checks, their errors may tell about the internals.
+func GetReadiness(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readiness := utils.CheckReadiness(r.Context(), db)
+
+		for _, check := range readiness.Checks {
+			if check.Status != utils.HealthOK {
+				log.Printf("Readiness check %s failed: %s\n", check.Name, check.Error)
+			}
+		}
+
+		if utils.AuthEnabled() {
+			if _, err := utils.Authenticate(db, r); err != nil {
+				for i := range readiness.Checks {
+					readiness.Checks[i].Error, readiness.Checks[i].Duration = "", 0
+				}
+			}
+		}
+
+		if readiness.Status != utils.HealthOK {
+			respondJSON(w, http.StatusServiceUnavailable, readiness)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, readiness)
+	}
+}
+
+// Build of the running server
+func GetVersion(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, utils.BuildVersion())
+	}
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"database/sql"
+	"github.com/mainak90/helmer/utils"
+	"log"
+	"net/http"
+)
+
+// Checks that the caller may perform an action on a resource, responds with 403 when not
+func authorize(w http.ResponseWriter, r *http.Request, action string, resource utils.Resource) bool {
+	identity := utils.IdentityFrom(r.Context())
+
+	if err := utils.Authorize(identity, action, resource); err != nil {
+		log.Printf("Refused %s %s: %-v\n", r.Method, r.URL.Path, err)
+		respondError(w, http.StatusForbidden, err)
+		return false
+	}
+
+	return true
+}
+
+// Store check letting the caller upload only the charts it may upload
+func uploadAllowed(r *http.Request) func(string) error {
+	identity := utils.IdentityFrom(r.Context())
+	return func(name string) error {
+		return utils.Authorize(identity, utils.ActionUpload, utils.Resource{Chart: name})
+	}
+}
+
+// Check if the caller may read a resource, used to filter listings
+func canRead(r *http.Request, resource utils.Resource) bool {
+	return utils.Allowed(utils.IdentityFrom(r.Context()), utils.ActionRead, resource)
+}
+
+// Get the permissions of the caller, for UIs to hide what the caller may not do
+func GetPermissions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, utils.GetPermissions(utils.IdentityFrom(r.Context())))
+	}
+}
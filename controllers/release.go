@@ -0,0 +1,476 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/mainak90/helmer/utils"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fetch the live status of a release, with the readiness of every object it owns and an aggregated health verdict
+func GetReleaseStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Helm Release Status Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		releaseName := params["name"]
+
+		namespace := params["namespace"]
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{Namespace: namespace, Namespaced: true}) {
+			return
+		}
+
+		actionConfig, err := utils.GetActionConfig(namespace)
+
+		if err != nil {
+			log.Printf("Error encountered: %-v\n", err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		rel, err := action.NewStatus(actionConfig).Run(releaseName)
+
+		if err != nil {
+			log.Printf("Error encountered while fetching status of release %s in namespace %s: %-v\n", releaseName, namespace, err)
+			if utils.IsReleaseNotFound(err) {
+				respondError(w, http.StatusNotFound, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{Namespace: namespace, Chart: rel.Chart.Metadata.Name, Namespaced: true}) {
+			return
+		}
+
+		resources, err := utils.GetReleaseResources(actionConfig, rel)
+
+		if err != nil {
+			log.Printf("Error encountered while building resources of release %s: %-v\n", releaseName, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		status := models.ReleaseStatus{
+			Name:      rel.Name,
+			Namespace: rel.Namespace,
+			Revision:  rel.Version,
+			Status:    rel.Info.Status.String(),
+			Chart:     rel.Chart.Metadata.Name,
+			Version:   rel.Chart.Metadata.Version,
+			Updated:   rel.Info.LastDeployed.Unix(),
+			Health:    utils.ReleaseHealth(rel, resources),
+			Resources: resources,
+		}
+
+		respondJSON(w, http.StatusOK, status)
+	}
+}
+
+// Upgrade a release with the override values it was last deployed with, opened from the database, and the vars of
+// the request on top. The version defaults to the deployed one and resolves like on install, valuesFrom defaults to
+// the references of the last deploy, which are read again.
+// {"version": "~0.6", "vars": ["image.tag=1.2.1"]}
+func UpgradeRelease(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Helm Release Upgrade Endpoint Hit")
+
+		var request models.Deploy
+
+		params := mux.Vars(r)
+
+		releaseName := params["name"]
+
+		namespace := params["namespace"]
+
+		// The body is optional, the release is deployed again as it was when it is left empty
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		entry := utils.AuditFrom(r.Context())
+
+		entry.Namespace, entry.Release = namespace, releaseName
+		entry.Params["vars"] = utils.RedactSetValues(request.Vars)
+		entry.Params["verify"], entry.Params["prerelease"] = request.Verify, request.Prerelease
+
+		if !authorize(w, r, utils.ActionDeploy, utils.Resource{Namespace: namespace, Namespaced: true}) {
+			return
+		}
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		last, stored, found, err := chartQuery.GetLastDeploy(db, releaseName, namespace)
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !found {
+			respondError(w, http.StatusNotFound, errors.Errorf("no successful deploy of release %s in namespace %s is recorded", releaseName, namespace))
+			return
+		}
+
+		vars, err := utils.StoredValues(stored, request.Vars)
+
+		if err != nil {
+			log.Printf("Unable to read the override values of deploy %d: %-v\n", last.ID, err)
+			if errors.Cause(err) == utils.ErrValuesRedacted {
+				respondError(w, http.StatusUnprocessableEntity, errors.Wrap(err, "send the redacted values again as vars"))
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		deploy := models.Deploy{
+			Name:       releaseName,
+			Namespace:  namespace,
+			Chart:      last.Chart,
+			Version:    last.Version,
+			Vars:       append(vars, request.Vars...),
+			ValuesFrom: last.ValuesFrom,
+			Verify:     request.Verify,
+			Prerelease: request.Prerelease,
+		}
+
+		if request.Version != "" {
+			deploy.Version = request.Version
+		}
+
+		if request.ValuesFrom != nil {
+			deploy.ValuesFrom = request.ValuesFrom
+		}
+
+		entry.Chart, entry.Version = deploy.Chart, deploy.Version
+
+		if !authorize(w, r, utils.ActionDeploy, utils.Resource{Namespace: namespace, Chart: deploy.Chart, Namespaced: true}) {
+			return
+		}
+
+		chart, err := utils.ResolveChartVersion(db, deploy.Chart, deploy.Version, deploy.Prerelease)
+
+		if err != nil {
+			log.Printf("No chart is retrieved from the provided data, cannot proceed: %-v\n", err)
+			if errors.Cause(err) == utils.ErrChartNotFound {
+				respondError(w, http.StatusNotFound, err)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if chart.Version != deploy.Version {
+			log.Printf("Resolved version %s of chart %s to %s\n", deploy.Version, deploy.Chart, chart.Version)
+			deploy.Requested = deploy.Version
+			deploy.Version = chart.Version
+			entry.Version = chart.Version
+		}
+
+		log.Printf("Upgrading release %s in namespace %s from deploy %d to chart %s version %s\n", releaseName, namespace, last.ID, deploy.Chart, deploy.Version)
+
+		deployRelease(w, db, entry, deploy, chart, true)
+	}
+}
+
+// Run the chart tests of a release, records the outcome alongside the release record in the database
+// {"timeout": "5m", "filter": ["test-connection"]}
+func TestRelease(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Helm Release Test Endpoint Hit")
+
+		var test models.ReleaseTest
+
+		params := mux.Vars(r)
+
+		releaseName := params["name"]
+
+		namespace := params["namespace"]
+
+		// The body is optional, tests run with the defaults when it is left empty
+		if err := json.NewDecoder(r.Body).Decode(&test); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		timeout := 5 * time.Minute
+
+		if test.Timeout != "" {
+			parsed, err := time.ParseDuration(test.Timeout)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			timeout = parsed
+		}
+
+		// Same as helm, tests run against the last revision of the release which is not deleted
+		actionConfig, rel, ok := lastRelease(w, r, utils.ActionDeploy, namespace, releaseName)
+
+		if !ok {
+			return
+		}
+
+		log.Printf("Running tests of release %s revision %d in namespace %s\n", rel.Name, rel.Version, namespace)
+
+		results, err := utils.RunReleaseTests(actionConfig, rel, timeout, test.Filter)
+
+		if err != nil {
+			log.Printf("Error encountered while running tests of release %s: %-v\n", releaseName, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		run := models.ReleaseTestRun{
+			Name:      rel.Name,
+			Namespace: rel.Namespace,
+			Revision:  rel.Version,
+			Status:    utils.TestOutcome(results),
+			Time:      time.Now().Unix(),
+			Tests:     results,
+		}
+
+		log.Printf("Tests of release %s finished with status %s\n", releaseName, run.Status)
+
+		chartQuery := chartQueries.ChartQueries{}
+
+		if _, err := chartQuery.UpdateDeployTest(db, releaseName, namespace, run.Status, run.Time); err != nil {
+			log.Printf("Failed to record test outcome of release %s in namespace %s\n", releaseName, namespace)
+		}
+
+		respondJSON(w, http.StatusOK, run)
+	}
+}
+
+// List the pods owned by a release
+func ListReleasePods(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Helm Release Pods Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		actionConfig, rel, ok := lastRelease(w, r, utils.ActionRead, params["namespace"], params["name"])
+
+		if !ok {
+			return
+		}
+
+		pods, err := utils.GetReleasePods(actionConfig, rel)
+
+		if err != nil {
+			log.Printf("Error encountered while listing pods of release %s: %-v\n", rel.Name, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		summaries := []models.Pod{}
+
+		for _, pod := range pods {
+			summaries = append(summaries, utils.PodSummary(pod))
+		}
+
+		respondJSON(w, http.StatusOK, summaries)
+	}
+}
+
+// Fetch, or follow when follow=true, the logs of a pod owned by a release.
+// Supported query parameters are container, tail (number of lines), since (duration like 10m) and previous.
+func GetReleasePodLogs(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Helm Release Pod Logs Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		podName := params["pod"]
+
+		query := r.URL.Query()
+
+		options := &corev1.PodLogOptions{
+			Container: query.Get("container"),
+			Follow:    query.Get("follow") == "true",
+			Previous:  query.Get("previous") == "true",
+		}
+
+		if tail := query.Get("tail"); tail != "" {
+			lines, err := strconv.ParseInt(tail, 10, 64)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			options.TailLines = &lines
+		}
+
+		if since := query.Get("since"); since != "" {
+			duration, err := time.ParseDuration(since)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			seconds := int64(duration.Seconds())
+			options.SinceSeconds = &seconds
+		}
+
+		actionConfig, rel, ok := lastRelease(w, r, utils.ActionRead, params["namespace"], params["name"])
+
+		if !ok {
+			return
+		}
+
+		pods, err := utils.GetReleasePods(actionConfig, rel)
+
+		if err != nil {
+			log.Printf("Error encountered while listing pods of release %s: %-v\n", rel.Name, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Only pods owned by the release can be read through this endpoint
+		var pod *corev1.Pod
+
+		for i := range pods {
+			if pods[i].Name == podName {
+				pod = &pods[i]
+			}
+		}
+
+		if pod == nil {
+			respondError(w, http.StatusNotFound, errors.Errorf("pod %s does not belong to release %s", podName, rel.Name))
+			return
+		}
+
+		clientset, err := actionConfig.KubernetesClientSet()
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		stream, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, options).Stream(r.Context())
+
+		if err != nil {
+			log.Printf("Error encountered while fetching logs of pod %s: %-v\n", pod.Name, err)
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		defer stream.Close()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		flusher, canFlush := w.(http.Flusher)
+
+		if !options.Follow || !canFlush {
+			io.Copy(w, stream)
+			return
+		}
+
+		// Push every chunk to the client as soon as it is read until either side closes the stream
+		buf := make([]byte, 4096)
+
+		for {
+			n, err := stream.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					return
+				}
+				flusher.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// List the kubernetes events involving the objects and pods of a release
+func ListReleaseEvents(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Helm Release Events Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		actionConfig, rel, ok := lastRelease(w, r, utils.ActionRead, params["namespace"], params["name"])
+
+		if !ok {
+			return
+		}
+
+		pods, err := utils.GetReleasePods(actionConfig, rel)
+
+		if err != nil {
+			log.Printf("Error encountered while listing pods of release %s: %-v\n", rel.Name, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		events, err := utils.GetReleaseEvents(actionConfig, rel, pods)
+
+		if err != nil {
+			log.Printf("Error encountered while listing events of release %s: %-v\n", rel.Name, err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, events)
+	}
+}
+
+// Resolves the client config of the namespace and the last revision of the release once the caller is allowed the
+// action on the namespace and the chart of the release, the error response is already written when it returns false.
+func lastRelease(w http.ResponseWriter, r *http.Request, verb string, namespace string, releaseName string) (*action.Configuration, *release.Release, bool) {
+	utils.AuditFrom(r.Context()).Namespace = namespace
+
+	if !authorize(w, r, verb, utils.Resource{Namespace: namespace, Namespaced: true}) {
+		return nil, nil, false
+	}
+
+	actionConfig, err := utils.GetActionConfig(namespace)
+
+	if err != nil {
+		log.Printf("Error encountered: %-v\n", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+
+	rel, err := actionConfig.Releases.Last(releaseName)
+
+	if err != nil {
+		log.Printf("Error encountered while fetching release %s in namespace %s: %-v\n", releaseName, namespace, err)
+		if utils.IsReleaseNotFound(err) {
+			respondError(w, http.StatusNotFound, err)
+			return nil, nil, false
+		}
+		respondError(w, http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+
+	entry := utils.AuditFrom(r.Context())
+
+	entry.Namespace, entry.Release = namespace, releaseName
+
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		entry.Chart, entry.Version = rel.Chart.Metadata.Name, rel.Chart.Metadata.Version
+	}
+
+	if rel.Chart != nil && rel.Chart.Metadata != nil && !authorize(w, r, verb, utils.Resource{Namespace: namespace, Chart: rel.Chart.Metadata.Name, Namespaced: true}) {
+		return nil, nil, false
+	}
+
+	return actionConfig, rel, true
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"database/sql"
+	"github.com/gorilla/mux"
+	"github.com/mainak90/helmer/models"
+	"github.com/mainak90/helmer/utils"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Serve the repository index, the stored charts merged with the charts of the upstream repositories
+func GetRepositoryIndex(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		index, err := utils.MergedIndex()
+
+		if err != nil {
+			log.Printf("Error encountered while merging the repository index: %-v\n", err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		for name := range index.Entries {
+			if !canRead(r, utils.Resource{Chart: name}) {
+				delete(index.Entries, name)
+			}
+		}
+
+		data, err := yaml.Marshal(index)
+
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// Serve the stored chart archives, laid out as <name>/<version>/<name>-<version>.tgz below the chart storage. Only
+// files are served, directories and the hidden temporary files of the storage are not found.
+func GetArchive(db *sql.DB) http.Handler {
+	files := http.StripPrefix("/archives/", http.FileServer(http.Dir(utils.ChartDir)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rel := strings.TrimPrefix(r.URL.Path, "/archives/")
+
+		for _, segment := range strings.Split(rel, "/") {
+			if segment == "" || strings.HasPrefix(segment, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		name := strings.SplitN(rel, "/", 2)[0]
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{Chart: name}) {
+			return
+		}
+
+		if info, err := os.Stat(filepath.Join(utils.ChartDir, filepath.FromSlash(rel))); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+// Serve the archive of an upstream chart version, fetching it into the chart storage on first request
+func GetUpstreamArchive(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Upstream Archive Endpoint Hit")
+
+		params := mux.Vars(r)
+
+		upstream, err := utils.GetUpstream(params["upstream"])
+
+		if err != nil {
+			respondError(w, http.StatusNotFound, err)
+			return
+		}
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{Chart: upstream.Prefix + params["name"]}) {
+			return
+		}
+
+		chart, err := utils.CacheUpstreamChart(db, upstream, params["name"], params["version"])
+
+		switch errors.Cause(err) {
+		case nil:
+		case utils.ErrChartNotFound:
+			respondError(w, http.StatusNotFound, err)
+			return
+		default:
+			log.Printf("Error encountered while caching upstream chart: %-v\n", err)
+			respondError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		http.ServeFile(w, r, chart.Path)
+	}
+}
+
+// List the configured mirrors with the status of their last sync
+func ListMirrors(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Mirror List Endpoint Hit")
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{}) {
+			return
+		}
+
+		statuses := []models.MirrorStatus{}
+
+		for _, m := range utils.Mirrors() {
+			statuses = append(statuses, m.Status())
+		}
+
+		respondJSON(w, http.StatusOK, statuses)
+	}
+}
+
+// Get the status of the last sync of a mirror
+func GetMirrorStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Mirror Status Endpoint Hit")
+
+		if !authorize(w, r, utils.ActionRead, utils.Resource{}) {
+			return
+		}
+
+		m, err := utils.GetMirror(mux.Vars(r)["name"])
+
+		if err != nil {
+			respondError(w, http.StatusNotFound, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, m.Status())
+	}
+}
+
+// Start a sync of a mirror without waiting for its interval, the sync runs in the background
+func SyncMirror(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println("Mirror Sync Endpoint Hit")
+
+		if !authorize(w, r, utils.ActionAdmin, utils.Resource{}) {
+			return
+		}
+
+		m, err := utils.GetMirror(mux.Vars(r)["name"])
+
+		if err != nil {
+			respondError(w, http.StatusNotFound, err)
+			return
+		}
+
+		if m.Status().State == utils.MirrorSyncing {
+			respondError(w, http.StatusConflict, errors.Wrapf(utils.ErrMirrorSyncing, "mirror %s", m.Name))
+			return
+		}
+
+		go func() {
+			if err := m.Sync(db); err != nil {
+				log.Printf("Sync of mirror %s failed: %-v\n", m.Name, err)
+			}
+		}()
+
+		respondJSON(w, http.StatusAccepted, m.Status())
+	}
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"github.com/mainak90/helmer/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetArchive(t *testing.T) {
+	defer func(dir string) { utils.ChartDir = dir }(utils.ChartDir)
+
+	dir, err := ioutil.TempDir("", "helmer-storage-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	utils.ChartDir = dir
+
+	os.MkdirAll(filepath.Join(dir, "app", "1.0.0"), os.ModePerm)
+	os.MkdirAll(filepath.Join(dir, "app", ".deleting"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(dir, "app", "1.0.0", "app-1.0.0.tgz"), []byte("archive"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app", "1.0.0", ".upload-1"), []byte("partial"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app", ".deleting", "app-0.1.0.tgz"), []byte("deleted"), 0644)
+
+	handler := GetArchive(nil)
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/archives/app/1.0.0/app-1.0.0.tgz", http.StatusOK},
+		{"/archives/", http.StatusNotFound},
+		{"/archives/app/", http.StatusNotFound},
+		{"/archives/app/1.0.0", http.StatusNotFound},
+		{"/archives/app/1.0.0/.upload-1", http.StatusNotFound},
+		{"/archives/app/.deleting/app-0.1.0.tgz", http.StatusNotFound},
+		{"/archives/app/../app/1.0.0/app-1.0.0.tgz", http.StatusNotFound},
+		{"/archives/app/1.0.0/missing.tgz", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+
+		if w.Code != c.status {
+			t.Errorf("%s: got status %d, want %d", c.path, w.Code, c.status)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// Writes the payload as a json response with the given status code
+func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// Writes the error as a json body with the given status code
+func respondError(w http.ResponseWriter, status int, err error) {
+	respondJSON(w, status, models.Error{Message: err.Error()})
+}
+
+// Caps the request body at a limit, bodies announced to be larger are refused with 413
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if r.ContentLength > limit {
+		respondError(w, http.StatusRequestEntityTooLarge, errors.Errorf("request body exceeds the limit of %d bytes", limit))
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	return true
+}
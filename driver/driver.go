@@ -3,9 +3,10 @@ package driver
 import (
 "database/sql"
 "log"
-"os"
+"net/url"
 
 "github.com/lib/pq"
+"github.com/mainak90/helmer/config"
 )
 
 var db *sql.DB
@@ -16,9 +17,12 @@ func logFatal(err error) {
 	}
 }
 
+// Connects to the database of database.url (HELMER_DATABASE_URL, ELEPHANTSQL_URL)
 func ConnectDB() *sql.DB {
 
-	pgUrl, err := pq.ParseURL(os.Getenv("ELEPHANTSQL_URL"))
+	dbUrl := config.Get().Database.URL
+
+	pgUrl, err := pq.ParseURL(dbUrl)
 
 	logFatal(err)
 
@@ -26,6 +30,18 @@ func ConnectDB() *sql.DB {
 	logFatal(err)
 	err = db.Ping()
 	logFatal(err)
-	log.Printf("Connected to postgresql url:  %+v\n", pgUrl)
+	log.Printf("Connected to postgresql url:  %+v\n", redactURL(dbUrl))
 	return db
 }
+
+// The url without its password, for the logs
+func redactURL(dbUrl string) string {
+	u, err := url.Parse(dbUrl)
+	if err != nil || u.User == nil {
+		return dbUrl
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
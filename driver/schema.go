@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"database/sql"
+	"log"
+)
+
+// Schema statements, applied in order on every start. Each one of them must be idempotent so that
+// existing databases are brought up to date without losing rows.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS charts (
+		id serial PRIMARY KEY,
+		name text NOT NULL,
+		version text NOT NULL,
+		path text NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS deploys (
+		id serial PRIMARY KEY,
+		deploymentName text NOT NULL,
+		deploymentDate bigint NOT NULL,
+		chartName text NOT NULL,
+		chartVersion text NOT NULL,
+		namespace text NOT NULL,
+		valuesOverrided text NOT NULL DEFAULT '',
+		state text NOT NULL
+	);`,
+	`ALTER TABLE deploys ADD COLUMN IF NOT EXISTS testStatus text NOT NULL DEFAULT '';`,
+	`ALTER TABLE deploys ADD COLUMN IF NOT EXISTS testDate bigint NOT NULL DEFAULT 0;`,
+	`ALTER TABLE deploys ADD COLUMN IF NOT EXISTS deletedDate bigint NOT NULL DEFAULT 0;`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS digest text NOT NULL DEFAULT '';`,
+	// Older versions recorded every upload, duplicates are collapsed to the latest row before the constraint is added
+	`DELETE FROM charts a USING charts b WHERE a.name = b.name AND a.version = b.version AND a.id < b.id;`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS charts_name_version ON charts (name, version);`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS lintStatus text NOT NULL DEFAULT '';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS lintMessages text NOT NULL DEFAULT '[]';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS provenance text NOT NULL DEFAULT 'none';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS signer text NOT NULL DEFAULT '';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS signerKey text NOT NULL DEFAULT '';`,
+	// Chart.yaml metadata used by the search, rows stored before are backfilled from their archive while indexed is false
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS appVersion text NOT NULL DEFAULT '';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS chartType text NOT NULL DEFAULT '';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS keywords text[] NOT NULL DEFAULT '{}';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS maintainers text[] NOT NULL DEFAULT '{}';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS indexed boolean NOT NULL DEFAULT false;`,
+	`CREATE INDEX IF NOT EXISTS charts_name ON charts (name);`,
+	`CREATE INDEX IF NOT EXISTS charts_app_version ON charts (appVersion);`,
+	`CREATE INDEX IF NOT EXISTS charts_keywords ON charts USING gin (keywords);`,
+	`ALTER TABLE deploys ADD COLUMN IF NOT EXISTS requestedVersion text NOT NULL DEFAULT '';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS sourceDigest text NOT NULL DEFAULT '';`,
+	`ALTER TABLE charts ADD COLUMN IF NOT EXISTS upstream text NOT NULL DEFAULT '';`,
+	// Only the sha256 of api tokens is stored, the token itself is shown once on creation
+	`CREATE TABLE IF NOT EXISTS api_tokens (
+		id serial PRIMARY KEY,
+		name text NOT NULL,
+		owner text NOT NULL,
+		tokenHash text NOT NULL UNIQUE,
+		prefix text NOT NULL,
+		created bigint NOT NULL,
+		expires bigint NOT NULL DEFAULT 0,
+		lastUsed bigint NOT NULL DEFAULT 0,
+		revoked bigint NOT NULL DEFAULT 0
+	);`,
+	`CREATE INDEX IF NOT EXISTS api_tokens_owner ON api_tokens (owner);`,
+	// Audit trail of the mutating requests, params holds the request parameters as json with the secrets redacted
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id bigserial PRIMARY KEY,
+		time bigint NOT NULL,
+		actor text NOT NULL,
+		method text NOT NULL,
+		sourceIp text NOT NULL,
+		action text NOT NULL,
+		cluster text NOT NULL,
+		namespace text NOT NULL DEFAULT '',
+		chart text NOT NULL DEFAULT '',
+		version text NOT NULL DEFAULT '',
+		release text NOT NULL DEFAULT '',
+		params text NOT NULL DEFAULT '{}',
+		result text NOT NULL,
+		status integer NOT NULL,
+		error text NOT NULL DEFAULT '',
+		duration bigint NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS audit_log_time ON audit_log (time);`,
+	`CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor);`,
+	// References to kubernetes secrets the values of a deploy were read from, the values themselves are not stored
+	`ALTER TABLE deploys ADD COLUMN IF NOT EXISTS valuesFrom text NOT NULL DEFAULT '[]';`,
+	// Helmer instance which ran the install, its pending deploys are taken for stale once its heartbeat lapses
+	`ALTER TABLE deploys ADD COLUMN IF NOT EXISTS instance text NOT NULL DEFAULT '';`,
+	// Heartbeats of the running instances, pending deploys of instances whose heartbeat lapsed are interrupted
+	`CREATE TABLE IF NOT EXISTS instances (
+		name text PRIMARY KEY,
+		heartbeat bigint NOT NULL
+	);`,
+	// How the owner of an api token authenticated and its groups at the time, restored on every use of the token
+	`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS ownerMethod text NOT NULL DEFAULT '';`,
+	`ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS ownerGroups text[] NOT NULL DEFAULT '{}';`,
+}
+
+// Migrate creates the tables helmer relies upon and adds the columns introduced by newer versions
+func Migrate(db *sql.DB) {
+	for _, statement := range migrations {
+		_, err := db.Exec(statement)
+		logFatal(err)
+	}
+	log.Printf("Database schema is up to date, %d statements applied", len(migrations))
+}
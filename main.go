@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	_ "encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mainak90/helmer/config"
 	"github.com/mainak90/helmer/controllers"
 	"github.com/mainak90/helmer/driver"
 	"github.com/mainak90/helmer/models"
 	"github.com/mainak90/helmer/utils"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/subosito/gotenv"
@@ -28,46 +36,179 @@ func logFatal(err error) {
 	}
 }
 
-type WithCORS struct {
-	r *mux.Router
-}
-
-func (s *WithCORS) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	if origin := req.Header.Get("Origin"); origin != "" {
-		res.Header().Set("Access-Control-Allow-Origin", origin)
-		res.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		res.Header().Set("Access-Control-Allow-Headers",
-			"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+// Runs helmer config print, which shows the effective config taking the same flags as the server
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: helmer config print [flags]")
+		os.Exit(2)
 	}
 
-	// Stop here for a Preflighted OPTIONS request.
-	if req.Method == "OPTIONS" {
+	cfg, err := config.Load(args[1:])
+
+	if err == flag.ErrHelp {
 		return
 	}
-	// Lets Gorilla work
-	s.r.ServeHTTP(res, req)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := config.Print(os.Stdout, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		configCommand(os.Args[2:])
+		return
+	}
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	utils.ChartDir = cfg.Storage.Dir
 	db = driver.ConnectDB()
+	driver.Migrate(db)
+	utils.RotateDeployValues(db)
+	utils.StartHeartbeat(db)
+	utils.IndexChartMetadata(db)
+	if err := utils.LoadRepositories(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := utils.LoadRBAC(); err != nil {
+		log.Fatalln(err)
+	}
+	cors := utils.LoadCORSPolicy()
 	router := mux.NewRouter()
 	log.Println("Adding chartUpload endpoint...")
-	router.HandleFunc("/uploadChart", controllers.UploadHelmChart(db)).Methods("POST")
+	router.HandleFunc("/uploadChart", controllers.Audited(db, utils.AuditChartUpload, controllers.UploadHelmChart(db))).Methods("POST")
+	log.Println("Adding chartImport endpoint...")
+	router.HandleFunc("/importChart", controllers.Audited(db, utils.AuditChartImport, controllers.ImportGitChart(db))).Methods("POST")
 	log.Println("Adding listChart endpoint...")
 	router.HandleFunc("/getChartList", controllers.ListHelmCharts(db)).Methods("GET")
+	log.Println("Adding searchCharts endpoint...")
+	router.HandleFunc("/searchCharts", controllers.SearchCharts(db)).Methods("GET")
+	log.Println("Adding chartDetail endpoints...")
+	router.HandleFunc("/charts/{name}/{version}", controllers.GetChartDetail(db)).Methods("GET")
+	router.HandleFunc("/charts/{name}", controllers.ListChartVersions(db)).Methods("GET")
+	log.Println("Adding deleteChart endpoint...")
+	router.HandleFunc("/charts/{name}/{version}", controllers.Audited(db, utils.AuditChartDelete, controllers.DeleteChart(db))).Methods("DELETE")
+	router.HandleFunc("/charts/{name}", controllers.Audited(db, utils.AuditChartDelete, controllers.DeleteChart(db))).Methods("DELETE")
+	log.Println("Adding chartLint endpoint...")
+	router.HandleFunc("/charts/{name}/{version}/lint", controllers.GetChartLint(db)).Methods("GET")
+	log.Println("Adding chartProvenance endpoint...")
+	router.HandleFunc("/charts/{name}/{version}/prov", controllers.Audited(db, utils.AuditChartProvenance, controllers.UploadChartProvenance(db))).Methods("POST")
 	log.Println("Adding deployChart endpoint...")
-	router.HandleFunc("/deployChart", controllers.DeployApp(db)).Methods("POST")
+	router.HandleFunc("/deployChart", controllers.Audited(db, utils.AuditReleaseInstall, controllers.DeployApp(db))).Methods("POST")
 	log.Println("Adding listHelmDeployments endpoint...")
 	router.HandleFunc("/getDeploymentList", controllers.ListDeployments(db)).Methods("GET")
 	log.Println("Adding deleteHelmDeployments endpoint...")
-	router.HandleFunc("/deleteDeployment/namespace/{namespace}/name/{name}", controllers.DeleteDeployment(db)).Methods("POST")
+	router.HandleFunc("/deleteDeployment/namespace/{namespace}/name/{name}", controllers.Audited(db, utils.AuditReleaseDelete, controllers.DeleteDeployment(db))).Methods("DELETE")
+	router.HandleFunc("/releases/{namespace}/{name}", controllers.Audited(db, utils.AuditReleaseDelete, controllers.DeleteDeployment(db))).Methods("DELETE")
+	log.Println("Adding releaseStatus endpoint...")
+	router.HandleFunc("/releases/{namespace}/{name}/status", controllers.GetReleaseStatus(db)).Methods("GET")
+	log.Println("Adding releaseTest endpoint...")
+	router.HandleFunc("/releases/{namespace}/{name}/upgrade", controllers.Audited(db, utils.AuditReleaseUpgrade, controllers.UpgradeRelease(db))).Methods("POST")
+	router.HandleFunc("/releases/{namespace}/{name}/test", controllers.Audited(db, utils.AuditReleaseTest, controllers.TestRelease(db))).Methods("POST")
+	log.Println("Adding releasePods endpoint...")
+	router.HandleFunc("/releases/{namespace}/{name}/pods", controllers.ListReleasePods(db)).Methods("GET")
+	log.Println("Adding releasePodLogs endpoint...")
+	router.HandleFunc("/releases/{namespace}/{name}/pods/{pod}/logs", controllers.GetReleasePodLogs(db)).Methods("GET")
+	log.Println("Adding releaseEvents endpoint...")
+	router.HandleFunc("/releases/{namespace}/{name}/events", controllers.ListReleaseEvents(db)).Methods("GET")
+	log.Println("Adding repository index endpoints...")
+	router.HandleFunc("/index.yaml", controllers.GetRepositoryIndex(db)).Methods("GET")
+	router.PathPrefix("/archives/").Handler(controllers.GetArchive(db)).Methods("GET")
+	router.HandleFunc("/upstreams/{upstream}/{name}/{version}/{file}", controllers.GetUpstreamArchive(db)).Methods("GET")
+	log.Println("Adding mirror endpoints...")
+	router.HandleFunc("/mirrors", controllers.ListMirrors(db)).Methods("GET")
+	router.HandleFunc("/mirrors/{name}/status", controllers.GetMirrorStatus(db)).Methods("GET")
+	router.HandleFunc("/mirrors/{name}/sync", controllers.Audited(db, utils.AuditMirrorSync, controllers.SyncMirror(db))).Methods("POST")
+	log.Println("Adding identity endpoint...")
+	router.HandleFunc("/me", controllers.GetIdentity(db)).Methods("GET")
+	router.HandleFunc("/me/permissions", controllers.GetPermissions(db)).Methods("GET")
+	log.Println("Adding token endpoints...")
+	router.HandleFunc("/tokens", controllers.Audited(db, utils.AuditTokenCreate, controllers.CreateToken(db))).Methods("POST")
+	router.HandleFunc("/tokens", controllers.ListTokens(db)).Methods("GET")
+	router.HandleFunc("/tokens/{id}", controllers.Audited(db, utils.AuditTokenRevoke, controllers.RevokeToken(db))).Methods("DELETE")
+	log.Println("Adding audit log endpoint...")
+	router.HandleFunc("/audit", controllers.GetAuditLog(db)).Methods("GET")
+	log.Println("Adding health endpoints...")
+	router.HandleFunc("/healthz", controllers.GetHealth(db)).Methods("GET")
+	router.HandleFunc("/readyz", controllers.GetReadiness(db)).Methods("GET")
+	router.HandleFunc("/version", controllers.GetVersion(db)).Methods("GET")
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
+	if err := utils.GenerateIndex(db); err != nil {
+		log.Printf("Failed to generate the repository index: %-v\n", err)
+	}
 	go func() {
 		utils.WatchFile(db)
 	}()
+	utils.StartMirrors(db)
+	server := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: controllers.CORS(cors, controllers.CSRF(cors, controllers.Authenticate(db, router))),
+	}
+	go func() {
+		var err error
+		if cfg.TLS.CertFile != "" {
+			server.TLSConfig, err = utils.ServerTLSConfig()
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Starting server on %s over tls, client certificates: %s...\n", cfg.Listen, cfg.TLS.ClientAuth)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Starting server on %s...\n", cfg.Listen)
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Received %s, shutting down\n", <-signals)
+	shutdown(server, cfg.Shutdown.Timeout.Duration)
+}
+
+// Stops accepting requests and waits up to the timeout for the requests and jobs in flight, the jobs are drained
+// alongside the requests as installs run within theirs. Jobs still running then have their deploys marked
+// interrupted, the database is left open for them to record their outcome until the process exits.
+func shutdown(server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	utils.StopMirrors()
+	utils.StopWatching()
+
+	drained := make(chan []utils.Job, 1)
+
 	go func() {
-		log.Println("Starting server...")
-		log.Fatal(http.ListenAndServe(":8900", &WithCORS{router}))
+		drained <- utils.DrainJobs(ctx)
 	}()
-	select {}
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Requests still in flight after %s: %-v\n", timeout, err)
+	}
+
+	left := <-drained
+
+	utils.StopHeartbeat()
+
+	utils.MarkInterrupted(db, left)
+
+	if len(left) > 0 {
+		log.Printf("Leaving the database open for %d jobs still running\n", len(left))
+	} else if err := db.Close(); err != nil {
+		log.Printf("Failed to close the database: %-v\n", err)
+	}
+
+	log.Println("Shut down")
 }
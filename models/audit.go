@@ -0,0 +1,38 @@
+package models
+
+// AuditEntry struct, maps the audit_log table, one row per mutating request
+type AuditEntry struct {
+	ID       int    `json:"id"`
+	Time     int64  `json:"time"`
+	Actor    string `json:"actor"`
+	Method   string `json:"method"`
+	SourceIP string `json:"sourceIp"`
+	// What was done, like chart.upload or release.install
+	Action    string `json:"action"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace,omitempty"`
+	Chart     string `json:"chart,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Release   string `json:"release,omitempty"`
+	// Parameters of the request, secrets are redacted before they are recorded
+	Params map[string]interface{} `json:"params"`
+	// success or failure, along with the http status and the error message
+	Result string `json:"result"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Milliseconds the request took
+	Duration int64 `json:"duration"`
+}
+
+// AuditFilter struct, maps the filters of an audit log query, empty fields match everything
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	Namespace string
+	Chart     string
+	Release   string
+	Result    string
+	Since     int64
+	Until     int64
+	Limit     int
+}
@@ -0,0 +1,35 @@
+package models
+
+// Identity struct, maps the caller a request was authenticated as
+type Identity struct {
+	Name string `json:"name"`
+	// How the caller authenticated: basic, token, oidc or anonymous when authentication is off
+	Method string   `json:"method"`
+	Groups []string `json:"groups"`
+	// Id of the api token used, 0 for other methods
+	TokenID int `json:"tokenId,omitempty"`
+}
+
+// Token struct, maps the api_tokens table, the token itself is only returned once on creation
+type Token struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	// Authentication method and groups of the owner when the token was created
+	OwnerMethod string   `json:"ownerMethod"`
+	OwnerGroups []string `json:"ownerGroups"`
+	// First characters of the token, enough to tell tokens apart
+	Prefix   string `json:"prefix"`
+	Created  int64  `json:"created"`
+	Expires  int64  `json:"expires"`
+	LastUsed int64  `json:"lastUsed"`
+	Revoked  int64  `json:"revoked"`
+	Token    string `json:"token,omitempty"`
+}
+
+// TokenRequest struct, maps a request to create an api token
+type TokenRequest struct {
+	Name string `json:"name"`
+	// Go duration the token is valid for, it never expires when empty unless created with another token
+	ExpiresIn string `json:"expiresIn"`
+}
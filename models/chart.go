@@ -1,22 +1,126 @@
 package models
 
-// Chart struct defining the data-model of the charts table
+import (
+	"encoding/json"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// Chart struct defining the data-model of the charts table. The original fields keep the capitalized keys existing
+// clients of /getChartList read.
 type Chart struct {
-	ID      int    `json:id`
-	Name    string `json:name`
-	Version string `json:version`
-	Path    string `json:path`
+	ID      int    `json:"ID"`
+	Name    string `json:"Name"`
+	Version string `json:"Version"`
+	Path    string `json:"Path"`
+	Digest  string `json:"digest"`
+	// Digest of the uploaded archive when the stored one differs from it, e.g. after vendoring dependencies
+	SourceDigest string `json:"sourceDigest,omitempty"`
+	// Highest severity raised by the lint run at upload, ok when clean
+	Lint string `json:"lint"`
+	// Result of the provenance verification, none when the chart was uploaded unsigned
+	Provenance string `json:"provenance"`
+	Signer     string `json:"signer,omitempty"`
+	SignerKey  string `json:"signerKey,omitempty"`
+	// Upstream repository the chart was fetched from, empty for uploaded charts
+	Upstream string `json:"upstream,omitempty"`
+	// Searchable metadata copied from Chart.yaml
+	Description string   `json:"description"`
+	AppVersion  string   `json:"appVersion"`
+	Type        string   `json:"type"`
+	Keywords    []string `json:"keywords"`
+	Maintainers []string `json:"maintainers"`
+}
+
+// GitImport struct, maps a request to import a chart from a git repository
+type GitImport struct {
+	URL string `json:"url"`
+	// Branch, tag or commit, the default branch when empty
+	Ref string `json:"ref"`
+	// Directory of the chart within the repository
+	Path string `json:"path"`
+	// Overrides of the Chart.yaml version and appVersion
+	Version    string `json:"version"`
+	AppVersion string `json:"appVersion"`
+}
+
+// DependencyStatus struct, maps how a dependency declared in Chart.yaml got resolved
+type DependencyStatus struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository"`
+	// Version picked for the dependency, empty when it could not be resolved
+	Resolved string `json:"resolved,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ChartSearch struct, maps a page of chart search results
+type ChartSearch struct {
+	Total    int     `json:"total"`
+	Page     int     `json:"page"`
+	PageSize int     `json:"pageSize"`
+	Results  []Chart `json:"results"`
 }
 
-// Deploy structs, mapped as data model for the deployment table
+// LintReport struct, maps the lint messages stored for a chart version
+type LintReport struct {
+	Name     string        `json:"name"`
+	Version  string        `json:"version"`
+	Status   string        `json:"status"`
+	Messages []LintMessage `json:"messages"`
+}
+
+// LintMessage struct, maps a single message raised by the helm linter
+type LintMessage struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+// Deploy structs, mapped as data model for the deployment table. The original fields keep the capitalized keys
+// existing clients of /getDeploymentList read, requests may use any case.
 type Deploy struct {
-	ID        int      `json:id`
-	Name      string   `json:name`
-	Chart     string   `json:chart`
-	Version   string   `json: version`
-	Namespace string   `json:namespace`
-	Vars      []string `json:vars`
-	Time      int64    `json:time`
-	Status    string   `json:status`
-	//"vars": ["mysqlRootPassword=admin@123,persistence.enabled=false,imagePullPolicy=Always"]
+	ID         int      `json:"ID"`
+	Name       string   `json:"Name"`
+	Chart      string   `json:"Chart"`
+	Version    string   `json:"Version"`
+	Namespace  string   `json:"Namespace"`
+	Vars       []string `json:"Vars"`
+	Time       int64    `json:"Time"`
+	Status     string   `json:"Status"`
+	TestStatus string   `json:"testStatus"`
+	TestTime   int64    `json:"testTime"`
+	// Set once the release is uninstalled, the record is kept unless purged
+	DeletedTime int64 `json:"deletedTime"`
+	// Request only, refuses charts which are not signed by a key of the keyring
+	Verify bool `json:"verify,omitempty"`
+	// Request only, lets latest and semver constraints resolve to pre-release versions
+	Prerelease bool `json:"prerelease,omitempty"`
+	// Version or constraint as requested, set when it resolved to a different version
+	Requested string `json:"requested,omitempty"`
+	// Values read from kubernetes secrets in the namespace of the release instead of being sent inline
+	ValuesFrom []SecretValue `json:"valuesFrom,omitempty"`
+	// Helmer instance which ran the install
+	Instance string `json:"instance,omitempty"`
+	//"vars": ["persistence.enabled=false,imagePullPolicy=Always"]
+}
+
+// ChartDetail struct, maps the content of a stored chart archive
+type ChartDetail struct {
+	Chart
+	Metadata     *chart.Metadata     `json:"metadata"`
+	Dependencies []*chart.Dependency `json:"dependencies"`
+	Values       string              `json:"values"`
+	Schema       json.RawMessage     `json:"schema,omitempty"`
+	Readme       string              `json:"readme"`
+	Templates    []string            `json:"templates"`
+}
+
+// SecretValue struct, maps a value read from a key of a kubernetes secret in the namespace of the release
+// {"secret": "mysql-credentials", "key": "password", "path": "mysqlRootPassword"}
+type SecretValue struct {
+	Secret string `json:"secret"`
+	Key    string `json:"key"`
+	// Path the value is set at, without one the key has to hold a values yaml
+	Path string `json:"path,omitempty"`
 }
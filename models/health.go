@@ -0,0 +1,26 @@
+package models
+
+// Readiness struct, maps the outcome of the readiness checks, ok only when every check is
+type Readiness struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// HealthCheck struct, maps the outcome of a single readiness check
+type HealthCheck struct {
+	Name string `json:"name"`
+	// ok or failed, a check running past its timeout has failed
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"durationMs,omitempty"`
+}
+
+// VersionInfo struct, maps the build of the running server
+type VersionInfo struct {
+	Version     string `json:"version"`
+	Commit      string `json:"commit"`
+	BuildDate   string `json:"buildDate"`
+	GoVersion   string `json:"goVersion"`
+	HelmVersion string `json:"helmVersion"`
+	Platform    string `json:"platform"`
+}
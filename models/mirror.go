@@ -0,0 +1,29 @@
+package models
+
+// MirrorStatus struct, maps the outcome of the last sync of a mirror
+type MirrorStatus struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// idle before the first sync, then syncing, ok or failed
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+	Started  int64  `json:"started"`
+	Finished int64  `json:"finished"`
+	NextSync int64  `json:"nextSync"`
+	// Versions matching the mirror rules upstream
+	Matched   int             `json:"matched"`
+	Synced    []MirrorVersion `json:"synced"`
+	Unchanged int             `json:"unchanged"`
+	Failed    []MirrorVersion `json:"failed"`
+	// Versions mirrored earlier which disappeared upstream, they are kept in the storage
+	Retained []MirrorVersion `json:"retained"`
+	// Versions stored from another source, an upload or another upstream, which the mirror leaves alone
+	Conflicts []MirrorVersion `json:"conflicts"`
+}
+
+// MirrorVersion struct, maps a chart version handled by a mirror sync
+type MirrorVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Error   string `json:"error,omitempty"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// Permission struct, maps what a role binding lets the caller do and where
+type Permission struct {
+	Role       string   `json:"role"`
+	Actions    []string `json:"actions"`
+	Clusters   []string `json:"clusters"`
+	Namespaces []string `json:"namespaces"`
+	Charts     []string `json:"charts"`
+}
+
+// Permissions struct, maps the permissions of the caller on the cluster helmer manages
+type Permissions struct {
+	Identity    Identity     `json:"identity"`
+	Cluster     string       `json:"cluster"`
+	Permissions []Permission `json:"permissions"`
+}
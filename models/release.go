@@ -0,0 +1,95 @@
+package models
+
+// ReleaseStatus struct, maps the live state of a helm release along with the objects it owns
+type ReleaseStatus struct {
+	Name      string           `json:"name"`
+	Namespace string           `json:"namespace"`
+	Revision  int              `json:"revision"`
+	Status    string           `json:"status"`
+	Chart     string           `json:"chart"`
+	Version   string           `json:"version"`
+	Updated   int64            `json:"updated"`
+	Health    string           `json:"health"`
+	Resources []ResourceStatus `json:"resources"`
+}
+
+// ResourceStatus struct, maps the live readiness of a single object rendered from the release manifest
+type ResourceStatus struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Ready     bool   `json:"ready"`
+	Status    string `json:"status"`
+	Restarts  int32  `json:"restarts,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Uninstall struct, maps the response of a release uninstall
+type Uninstall struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	Status      string `json:"status"`
+	Info        string `json:"info,omitempty"`
+	KeepHistory bool   `json:"keepHistory"`
+	Records     int64  `json:"records"`
+}
+
+// Error struct, the json body sent back on failed requests
+type Error struct {
+	Message string `json:"message"`
+}
+
+// ReleaseTest struct, maps the options of a request running the tests of a release
+// {"timeout": "5m", "filter": ["test-connection", "!test-slow"]}
+type ReleaseTest struct {
+	Timeout string   `json:"timeout"`
+	Filter  []string `json:"filter"`
+}
+
+// ReleaseTestRun struct, maps the outcome of a run of the release tests
+type ReleaseTestRun struct {
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace"`
+	Revision  int          `json:"revision"`
+	Status    string       `json:"status"`
+	Time      int64        `json:"time"`
+	Tests     []TestResult `json:"tests"`
+}
+
+// TestResult struct, maps the phase and the captured logs of a single test hook
+type TestResult struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Phase     string `json:"phase"`
+	Started   int64  `json:"started,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Logs      string `json:"logs,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Pod struct, maps the summary of a pod owned by a release
+type Pod struct {
+	Name           string   `json:"name"`
+	Namespace      string   `json:"namespace"`
+	Phase          string   `json:"phase"`
+	Status         string   `json:"status"`
+	Ready          bool     `json:"ready"`
+	Restarts       int32    `json:"restarts"`
+	Node           string   `json:"node"`
+	Created        int64    `json:"created"`
+	InitContainers []string `json:"initContainers,omitempty"`
+	Containers     []string `json:"containers"`
+}
+
+// Event struct, maps a kubernetes event involving one of the objects of a release
+type Event struct {
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Count     int32  `json:"count"`
+	FirstSeen int64  `json:"firstSeen"`
+	LastSeen  int64  `json:"lastSeen"`
+}
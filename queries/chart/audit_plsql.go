@@ -0,0 +1,124 @@
+package chartQueries
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/mainak90/helmer/models"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Columns selected for every audit entry, in the order of auditFields
+const auditColumns = "id, time, actor, method, sourceIp, action, cluster, namespace, chart, version, release, params, result, status, error, duration"
+
+// Record an audit entry, the parameters are stored as json
+func (b ChartQueries) AddAuditEntry(db *sql.DB, entry models.AuditEntry) (int, error) {
+	params, err := json.Marshal(entry.Params)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.QueryRow("insert into audit_log (time, actor, method, sourceIp, action, cluster, namespace, chart, version, release, params, result, status, error, duration) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id;",
+		entry.Time, entry.Actor, entry.Method, entry.SourceIP, entry.Action, entry.Cluster, entry.Namespace, entry.Chart, entry.Version,
+		entry.Release, string(params), entry.Result, entry.Status, entry.Error, entry.Duration).Scan(&entry.ID)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
+
+	return entry.ID, nil
+}
+
+// Fetch the audit entries matching a filter, newest first
+func (b ChartQueries) GetAuditEntries(db *sql.DB, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+
+	err := b.EachAuditEntry(db, filter, func(entry models.AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+
+	return entries, err
+}
+
+// Walk the audit entries matching a filter newest first without holding them all, used by the exports.
+// The action filter also matches the actions below it, release matches release.install.
+func (b ChartQueries) EachAuditEntry(db *sql.DB, filter models.AuditFilter, fn func(models.AuditEntry) error) error {
+	conditions := []string{}
+	args := []interface{}{}
+
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), -1))
+	}
+
+	if filter.Actor != "" {
+		where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		where("(action = ? or action like ? || '.%')", filter.Action)
+	}
+	if filter.Namespace != "" {
+		where("namespace = ?", filter.Namespace)
+	}
+	if filter.Chart != "" {
+		where("chart = ?", filter.Chart)
+	}
+	if filter.Release != "" {
+		where("release = ?", filter.Release)
+	}
+	if filter.Result != "" {
+		where("result = ?", filter.Result)
+	}
+	if filter.Since != 0 {
+		where("time >= ?", filter.Since)
+	}
+	if filter.Until != 0 {
+		where("time < ?", filter.Until)
+	}
+
+	query := "select " + auditColumns + " from audit_log"
+
+	if len(conditions) > 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+
+	query += " order by id desc"
+
+	if filter.Limit > 0 {
+		query += " limit " + strconv.Itoa(filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.AuditEntry
+		var params string
+
+		err := rows.Scan(&entry.ID, &entry.Time, &entry.Actor, &entry.Method, &entry.SourceIP, &entry.Action, &entry.Cluster, &entry.Namespace,
+			&entry.Chart, &entry.Version, &entry.Release, &params, &entry.Result, &entry.Status, &entry.Error, &entry.Duration)
+
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(params), &entry.Params); err != nil {
+			log.Printf("Unreadable parameters of audit entry %d: %s", entry.ID, err)
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
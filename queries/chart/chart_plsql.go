@@ -2,6 +2,8 @@ package chartQueries
 
 import (
 	"database/sql"
+	"encoding/json"
+	"github.com/lib/pq"
 	"github.com/mainak90/helmer/models"
 	"log"
 	"strings"
@@ -10,6 +12,15 @@ import (
 type ChartQueries struct {
 }
 
+// Columns selected for every chart, in the order of chartFields
+const chartColumns = "id, name, version, path, digest, sourceDigest, lintStatus, provenance, signer, signerKey, upstream, description, appVersion, chartType, keywords, maintainers"
+
+// Scan destinations of the chart columns
+func chartFields(chart *models.Chart) []interface{} {
+	return []interface{}{&chart.ID, &chart.Name, &chart.Version, &chart.Path, &chart.Digest, &chart.SourceDigest, &chart.Lint, &chart.Provenance,
+		&chart.Signer, &chart.SignerKey, &chart.Upstream, &chart.Description, &chart.AppVersion, &chart.Type, pq.Array(&chart.Keywords), pq.Array(&chart.Maintainers)}
+}
+
 func logFatal(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -18,11 +29,11 @@ func logFatal(err error) {
 
 // Getting chart list for postgresql database
 func (b ChartQueries) GetCharts(db *sql.DB, chart models.Chart, charts []models.Chart) []models.Chart {
-	rows, err := db.Query("select * from charts")
+	rows, err := db.Query("select "+chartColumns+" from charts")
 	logFatal(err)
 
 	for rows.Next() {
-		err := rows.Scan(&chart.ID, &chart.Name, &chart.Version, &chart.Path)
+		err := rows.Scan(chartFields(&chart)...)
 
 		if err == sql.ErrNoRows {
 			log.Printf("No rows found!!")
@@ -43,22 +54,175 @@ func (b ChartQueries) GetCharts(db *sql.DB, chart models.Chart, charts []models.
 
 // Getting a specific chart from postgresql database, not needed for now, can be used later.
 func (b ChartQueries) GetChart(db *sql.DB, chart models.Chart, id int) models.Chart {
-	rows := db.QueryRow("select * from charts where id=$1", id)
+	rows := db.QueryRow("select "+chartColumns+" from charts where id=$1", id)
 
-	err := rows.Scan(&chart.ID, &chart.Name, &chart.Version, &chart.Path)
+	err := rows.Scan(chartFields(&chart)...)
 	logFatal(err)
 
 	return chart
 }
 
-// Outlays the database action after a new chart is added
-func (b ChartQueries) AddChart(db *sql.DB, chart models.Chart) int {
-	err := db.QueryRow("insert into charts (name, version, path) values($1, $2, $3) RETURNING id;",
-		chart.Name, chart.Version, chart.Path).Scan(&chart.ID)
+// Fetch a chart by name and version, found is false when no such version is stored.
+func (b ChartQueries) GetChartVersion(db *sql.DB, name string, version string) (models.Chart, bool, error) {
+	var chart models.Chart
 
-	logFatal(err)
+	err := db.QueryRow("select "+chartColumns+" from charts where name=$1 and version=$2", name, version).
+		Scan(chartFields(&chart)...)
+
+	if err == sql.ErrNoRows {
+		return chart, false, nil
+	}
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return chart, false, err
+	}
+
+	return chart, true, nil
+}
+
+// Fetch every stored version of a chart, in no particular order.
+func (b ChartQueries) GetChartVersions(db *sql.DB, name string) ([]models.Chart, error) {
+	return b.queryCharts(db, "select "+chartColumns+" from charts where name=$1", name)
+}
+
+// Fetch the charts fetched from an upstream repository or mirror
+func (b ChartQueries) GetUpstreamCharts(db *sql.DB, upstream string) ([]models.Chart, error) {
+	return b.queryCharts(db, "select "+chartColumns+" from charts where upstream=$1", upstream)
+}
+
+// Fetch the charts whose Chart.yaml metadata was not copied into the table yet
+func (b ChartQueries) GetUnindexedCharts(db *sql.DB) ([]models.Chart, error) {
+	return b.queryCharts(db, "select "+chartColumns+" from charts where indexed=false")
+}
+
+// Record the searchable Chart.yaml metadata of a chart version
+func (b ChartQueries) UpdateChartMetadata(db *sql.DB, chart models.Chart) (int64, error) {
+	result, err := db.Exec("update charts set description=$1, appVersion=$2, chartType=$3, keywords=$4, maintainers=$5, indexed=true where id=$6;",
+		chart.Description, chart.AppVersion, chart.Type, pq.Array(chart.Keywords), pq.Array(chart.Maintainers), chart.ID)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Search the chart catalog, every empty filter matches all charts. Text matches name, description and keywords
+// case insensitively, maintainer matches the name or email of any maintainer. Results are ordered by name then id.
+func (b ChartQueries) SearchCharts(db *sql.DB, text string, appVersion string, chartType string, maintainer string) ([]models.Chart, error) {
+	return b.queryCharts(db, "select "+chartColumns+" from charts where "+
+		"($1 = '' or name ilike $1 or description ilike $1 or exists (select 1 from unnest(keywords) k where k ilike $1)) and "+
+		"($2 = '' or appVersion = $2) and "+
+		"($3 = '' or chartType = $3) and "+
+		"($4 = '' or exists (select 1 from unnest(maintainers) m where m ilike $4)) "+
+		"order by name, id", likePattern(text), appVersion, chartType, likePattern(maintainer))
+}
+
+func (b ChartQueries) queryCharts(db *sql.DB, query string, args ...interface{}) ([]models.Chart, error) {
+	charts := []models.Chart{}
+
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var chart models.Chart
+		if err := rows.Scan(chartFields(&chart)...); err != nil {
+			return nil, err
+		}
+		charts = append(charts, chart)
+	}
+
+	return charts, rows.Err()
+}
+
+// Substring pattern for ilike, the wildcards of the searched text are matched literally
+func likePattern(text string) string {
+	if text == "" {
+		return ""
+	}
+	return "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(text) + "%"
+}
+
+// Outlays the database action after a new chart is added, an overwritten version keeps its row and id. Without
+// overwrite an existing row is left alone and false is returned, the unique index decides between concurrent uploads.
+func (b ChartQueries) AddChart(db *sql.DB, chart models.Chart, lint []models.LintMessage, overwrite bool) (int, bool, error) {
+	messages, err := json.Marshal(lint)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	conflict := "ON CONFLICT (name, version) DO NOTHING"
+
+	if overwrite {
+		conflict = "ON CONFLICT (name, version) DO UPDATE SET path=EXCLUDED.path, digest=EXCLUDED.digest, sourceDigest=EXCLUDED.sourceDigest, lintStatus=EXCLUDED.lintStatus, lintMessages=EXCLUDED.lintMessages, provenance=EXCLUDED.provenance, signer=EXCLUDED.signer, signerKey=EXCLUDED.signerKey, upstream=EXCLUDED.upstream, description=EXCLUDED.description, appVersion=EXCLUDED.appVersion, chartType=EXCLUDED.chartType, keywords=EXCLUDED.keywords, maintainers=EXCLUDED.maintainers, indexed=true"
+	}
+
+	err = db.QueryRow("insert into charts (name, version, path, digest, sourceDigest, lintStatus, lintMessages, provenance, signer, signerKey, upstream, description, appVersion, chartType, keywords, maintainers, indexed) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, true) "+conflict+" RETURNING id;",
+		chart.Name, chart.Version, chart.Path, chart.Digest, chart.SourceDigest, chart.Lint, string(messages), chart.Provenance, chart.Signer, chart.SignerKey, chart.Upstream,
+		chart.Description, chart.AppVersion, chart.Type, pq.Array(chart.Keywords), pq.Array(chart.Maintainers)).Scan(&chart.ID)
+
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, false, err
+	}
+
+	return chart.ID, true, nil
+}
+
+// Remove a chart row by its id, used to give up the row of a new version whose archive could not be stored
+func (b ChartQueries) RemoveChartByID(db *sql.DB, id int) error {
+	_, err := db.Exec("DELETE FROM charts WHERE id=$1;", id)
+	return err
+}
+
+// Record the provenance verification result and the signer of a chart version
+func (b ChartQueries) UpdateChartProvenance(db *sql.DB, chart models.Chart) (int64, error) {
+	result, err := db.Exec("update charts set provenance=$1, signer=$2, signerKey=$3 where id=$4;",
+		chart.Provenance, chart.Signer, chart.SignerKey, chart.ID)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Fetch the lint report stored for a chart version, found is false when no such version is stored.
+func (b ChartQueries) GetChartLint(db *sql.DB, name string, version string) (models.LintReport, bool, error) {
+	report := models.LintReport{Name: name, Version: version}
 
-	return chart.ID
+	var messages string
+
+	err := db.QueryRow("select lintStatus, lintMessages from charts where name=$1 and version=$2", name, version).Scan(&report.Status, &messages)
+
+	if err == sql.ErrNoRows {
+		return report, false, nil
+	}
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return report, false, err
+	}
+
+	if err := json.Unmarshal([]byte(messages), &report.Messages); err != nil {
+		return report, true, err
+	}
+
+	return report, true, nil
 }
 
 // Incase chart is updated, implementation is this one, but yet not executed upon.
@@ -94,6 +258,54 @@ func (b ChartQueries) RemoveChart(db *sql.DB, name string, version string) int64
 	return rowsDeleted
 }
 
+// Remove the chart version, or every version of the chart when version is empty, as part of the given transaction.
+func (b ChartQueries) RemoveChartsTx(tx *sql.Tx, name string, version string) ([]models.Chart, error) {
+	charts := []models.Chart{}
+
+	rows, err := tx.Query("DELETE FROM charts WHERE name=$1 AND ($2 = '' OR version=$2) RETURNING id, name, version, path;", name, version)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var chart models.Chart
+		if err := rows.Scan(&chart.ID, &chart.Name, &chart.Version, &chart.Path); err != nil {
+			return nil, err
+		}
+		charts = append(charts, chart)
+	}
+
+	return charts, rows.Err()
+}
+
+// Fetch the releases still installed from the chart version, or from any version of the chart when version is empty.
+func (b ChartQueries) GetChartDeploys(db *sql.DB, name string, version string) ([]models.Deploy, error) {
+	deploys := []models.Deploy{}
+
+	rows, err := db.Query("select id, deploymentName, deploymentDate, chartName, chartVersion, namespace, state from deploys where chartName=$1 and ($2 = '' or chartVersion=$2) and deletedDate=0 and state != 'Failed'", name, version)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var deploy models.Deploy
+		if err := rows.Scan(&deploy.ID, &deploy.Name, &deploy.Time, &deploy.Chart, &deploy.Version, &deploy.Namespace, &deploy.Status); err != nil {
+			return nil, err
+		}
+		deploys = append(deploys, deploy)
+	}
+
+	return deploys, rows.Err()
+}
+
 // To supply the chart path is FS to the addChart method to pick charts from using chart.Load
 func (b ChartQueries) GetChartPath(db *sql.DB, chart models.Chart, name string, version string) string {
 	rows := db.QueryRow("select path from charts where name=$1 and version=$2", name, version)
@@ -111,11 +323,17 @@ func (b ChartQueries) GetChartPath(db *sql.DB, chart models.Chart, name string,
 	return chart.Path
 }
 
-// Add entry to add the chart release record into the database table.
-func (b ChartQueries) AddDeploy(db *sql.DB, deploy models.Deploy) (int, error) {
-	vars := strings.Join(deploy.Vars, ",")
-	err := db.QueryRow("insert into deploys (deploymentName, deploymentDate, chartName, chartVersion, namespace, valuesOverrided, state) values($1, $2, $3, $4, $5, $6, $7) RETURNING id;",
-		deploy.Name, deploy.Time, deploy.Chart, deploy.Version, deploy.Namespace, vars, deploy.Status).Scan(&deploy.ID)
+// Add entry to add the chart release record into the database table, with the override values already sealed.
+// Secret references are stored as they are, they hold no secret.
+func (b ChartQueries) AddDeploy(db *sql.DB, deploy models.Deploy, values string) (int, error) {
+	valuesFrom, err := json.Marshal(deploy.ValuesFrom)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.QueryRow("insert into deploys (deploymentName, deploymentDate, chartName, chartVersion, namespace, valuesOverrided, state, requestedVersion, valuesFrom, instance) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;",
+		deploy.Name, deploy.Time, deploy.Chart, deploy.Version, deploy.Namespace, values, deploy.Status, deploy.Requested, string(valuesFrom), deploy.Instance).Scan(&deploy.ID)
 
 	if err != nil {
 		log.Printf("Error encountered: %s", err)
@@ -125,13 +343,115 @@ func (b ChartQueries) AddDeploy(db *sql.DB, deploy models.Deploy) (int, error) {
 	return deploy.ID, nil
 }
 
+// Record the outcome of a deploy which was added as pending
+func (b ChartQueries) UpdateDeployState(db *sql.DB, id int, state string, time int64) error {
+	_, err := db.Exec("update deploys set state=$1, deploymentDate=$2 where id=$3;", state, time, id)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+	}
+
+	return err
+}
+
+// Mark the given deploys which are still pending as interrupted
+func (b ChartQueries) MarkDeploysInterrupted(db *sql.DB, ids []int) (int64, error) {
+	result, err := db.Exec("update deploys set state=$1 where state=$2 and id = any($3);", "Interrupted", "Pending", pq.Array(ids))
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Record that an instance is alive, instances without a heartbeat since long are forgotten
+func (b ChartQueries) RecordHeartbeat(db *sql.DB, instance string, time int64) error {
+	if _, err := db.Exec("insert into instances (name, heartbeat) values($1, $2) ON CONFLICT (name) DO UPDATE SET heartbeat=EXCLUDED.heartbeat;", instance, time); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("delete from instances where heartbeat < $1;", time-24*60*60)
+	return err
+}
+
+// Mark the pending deploys of instances without a heartbeat since lapsed as interrupted, deploys recorded without an
+// instance once they are older than before
+func (b ChartQueries) MarkStaleDeploysInterrupted(db *sql.DB, lapsed int64, before int64) (int64, error) {
+	result, err := db.Exec("update deploys set state=$1 where state=$2 and ((instance = '' and deploymentDate < $4) or (instance <> '' and not exists (select 1 from instances where instances.name = deploys.instance and instances.heartbeat >= $3)));",
+		"Interrupted", "Pending", lapsed, before)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Fetch the stored override values of every deploy by id, deploys without any are left out
+func (b ChartQueries) GetDeployValues(db *sql.DB) (map[int]string, error) {
+	rows, err := db.Query("select id, valuesOverrided from deploys where valuesOverrided <> ''")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	values := map[int]string{}
+
+	for rows.Next() {
+		var id int
+		var value string
+
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+
+		values[id] = value
+	}
+
+	return values, rows.Err()
+}
+
+// Replace the stored override values of a deploy
+func (b ChartQueries) UpdateDeployValues(db *sql.DB, id int, values string) error {
+	_, err := db.Exec("update deploys set valuesOverrided=$1 where id=$2;", values, id)
+	return err
+}
+
+// Fetch the last successful deploy of a release which is not uninstalled, with its override values as stored
+func (b ChartQueries) GetLastDeploy(db *sql.DB, name string, namespace string) (models.Deploy, string, bool, error) {
+	var deploy models.Deploy
+	var values, valuesFrom string
+
+	err := db.QueryRow("select id, deploymentName, chartName, chartVersion, namespace, valuesOverrided, valuesFrom from deploys where deploymentName=$1 and namespace=$2 and state=$3 and deletedDate=0 order by id desc limit 1;",
+		name, namespace, "Success").Scan(&deploy.ID, &deploy.Name, &deploy.Chart, &deploy.Version, &deploy.Namespace, &values, &valuesFrom)
+
+	if err == sql.ErrNoRows {
+		return deploy, "", false, nil
+	}
+
+	if err != nil {
+		return deploy, "", false, err
+	}
+
+	if err := json.Unmarshal([]byte(valuesFrom), &deploy.ValuesFrom); err != nil {
+		return deploy, "", false, err
+	}
+
+	return deploy, values, true, nil
+}
+
 // Fetch deployment list from the table
 func (b ChartQueries) GetDeploys(db *sql.DB, deploy models.Deploy, deploys []models.Deploy) []models.Deploy {
-	rows, err := db.Query("select id, deploymentName, deploymentDate, chartName, chartVersion, namespace, state from deploys")
+	rows, err := db.Query("select id, deploymentName, deploymentDate, chartName, chartVersion, namespace, state, testStatus, testDate, deletedDate, requestedVersion from deploys")
 	logFatal(err)
 
 	for rows.Next() {
-		err := rows.Scan(&deploy.ID, &deploy.Name, &deploy.Time, &deploy.Chart, &deploy.Version, &deploy.Namespace, &deploy.Status)
+		err := rows.Scan(&deploy.ID, &deploy.Name, &deploy.Time, &deploy.Chart, &deploy.Version, &deploy.Namespace, &deploy.Status, &deploy.TestStatus, &deploy.TestTime, &deploy.DeletedTime, &deploy.Requested)
 
 		if err == sql.ErrNoRows {
 			log.Printf("No rows found!!")
@@ -150,21 +470,40 @@ func (b ChartQueries) GetDeploys(db *sql.DB, deploy models.Deploy, deploys []mod
 	return deploys
 }
 
-// Incase a deploy does not exist or manually deleted directly from helm cli or from kubernetes, delete the release ref from the database.
-func (b ChartQueries) RemoveDeployment(db *sql.DB, name string) (int64, error) {
-	result, err := db.Exec("delete from deploys where name = $1;", name)
-	logFatal(err)
+// Record the outcome of the last test run on the latest release record of the deployment
+func (b ChartQueries) UpdateDeployTest(db *sql.DB, name string, namespace string, status string, time int64) (int64, error) {
+	result, err := db.Exec("update deploys set testStatus=$1, testDate=$2 where id = (select max(id) from deploys where deploymentName=$3 and namespace=$4);",
+		status, time, name, namespace)
 
-	rowsDeleted, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
 
-	if err == sql.ErrNoRows {
-		log.Printf("No rows found!!")
+	return result.RowsAffected()
+}
+
+// Mark the release records of the deployment as uninstalled, the rows are kept so the release history stays visible.
+func (b ChartQueries) MarkDeploymentDeleted(db *sql.DB, name string, namespace string, time int64) (int64, error) {
+	result, err := db.Exec("update deploys set state=$1, deletedDate=$2 where deploymentName=$3 and namespace=$4 and deletedDate=0;",
+		"Uninstalled", time, name, namespace)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
 		return 0, err
 	}
 
-	if err != nil && err != sql.ErrNoRows {
-		logFatal(err)
+	return result.RowsAffected()
+}
+
+// Incase a deploy does not exist or manually deleted directly from helm cli or from kubernetes, delete the release ref from the database.
+func (b ChartQueries) RemoveDeployment(db *sql.DB, name string, namespace string) (int64, error) {
+	result, err := db.Exec("delete from deploys where deploymentName = $1 and namespace = $2;", name, namespace)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
 	}
 
-	return rowsDeleted, nil
+	return result.RowsAffected()
 }
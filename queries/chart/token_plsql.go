@@ -0,0 +1,106 @@
+package chartQueries
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/mainak90/helmer/models"
+	"log"
+)
+
+// Columns selected for every api token, in the order of tokenFields
+const tokenColumns = "id, name, owner, ownerMethod, ownerGroups, prefix, created, expires, lastUsed, revoked"
+
+// Scan destinations of the token columns
+func tokenFields(token *models.Token) []interface{} {
+	return []interface{}{&token.ID, &token.Name, &token.Owner, &token.OwnerMethod, pq.Array(&token.OwnerGroups), &token.Prefix, &token.Created, &token.Expires, &token.LastUsed, &token.Revoked}
+}
+
+// Record a new api token by the hash of the token
+func (b ChartQueries) AddToken(db *sql.DB, token models.Token, hash string) (int, error) {
+	err := db.QueryRow("insert into api_tokens (name, owner, ownerMethod, ownerGroups, tokenHash, prefix, created, expires) values($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;",
+		token.Name, token.Owner, token.OwnerMethod, pq.Array(token.OwnerGroups), hash, token.Prefix, token.Created, token.Expires).Scan(&token.ID)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
+
+	return token.ID, nil
+}
+
+// Fetch the api tokens of an owner, revoked and expired ones included
+func (b ChartQueries) GetTokens(db *sql.DB, owner string) ([]models.Token, error) {
+	rows, err := db.Query("select "+tokenColumns+" from api_tokens where owner=$1 order by id", owner)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []models.Token{}
+
+	for rows.Next() {
+		var token models.Token
+
+		if err := rows.Scan(tokenFields(&token)...); err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Fetch an api token by the hash of the token
+func (b ChartQueries) GetTokenByHash(db *sql.DB, hash string) (models.Token, bool, error) {
+	var token models.Token
+
+	err := db.QueryRow("select "+tokenColumns+" from api_tokens where tokenHash=$1", hash).Scan(tokenFields(&token)...)
+
+	if err == sql.ErrNoRows {
+		return token, false, nil
+	}
+
+	if err != nil {
+		return token, false, err
+	}
+
+	return token, true, nil
+}
+
+// Fetch an api token by its id
+func (b ChartQueries) GetTokenByID(db *sql.DB, id int) (models.Token, bool, error) {
+	var token models.Token
+
+	err := db.QueryRow("select "+tokenColumns+" from api_tokens where id=$1", id).Scan(tokenFields(&token)...)
+
+	if err == sql.ErrNoRows {
+		return token, false, nil
+	}
+
+	if err != nil {
+		return token, false, err
+	}
+
+	return token, true, nil
+}
+
+// Record the last use of an api token
+func (b ChartQueries) TouchToken(db *sql.DB, id int, time int64) error {
+	_, err := db.Exec("update api_tokens set lastUsed=$1 where id=$2;", time, id)
+	return err
+}
+
+// Revoke an api token of an owner, tokens already revoked are left alone
+func (b ChartQueries) RevokeToken(db *sql.DB, id int, owner string, time int64) (int64, error) {
+	result, err := db.Exec("update api_tokens set revoked=$1 where id=$2 and owner=$3 and revoked=0;", time, id, owner)
+
+	if err != nil {
+		log.Printf("Error encountered: %s", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditChartUpload     = "chart.upload"
+	AuditChartImport     = "chart.import"
+	AuditChartDelete     = "chart.delete"
+	AuditChartProvenance = "chart.provenance"
+	AuditReleaseInstall  = "release.install"
+	AuditReleaseUpgrade  = "release.upgrade"
+	AuditReleaseDelete   = "release.uninstall"
+	AuditReleaseTest     = "release.test"
+	AuditMirrorSync      = "mirror.sync"
+	AuditTokenCreate     = "token.create"
+	AuditTokenRevoke     = "token.revoke"
+)
+
+// Results of an audited request
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// Placeholder recorded instead of a secret
+const Redacted = "<redacted>"
+
+// Parameter names whose values are never recorded
+var sensitiveKey = regexp.MustCompile(`(?i)pass(word|wd)?|secret|token|key|credential|auth|private|cert`)
+
+type auditKey struct{}
+
+// Attaches the audit entry of a request to its context, handlers fill in what they learn about the target
+func WithAudit(ctx context.Context, entry *models.AuditEntry) context.Context {
+	return context.WithValue(ctx, auditKey{}, entry)
+}
+
+// Audit entry of a request, requests which are not audited get a throwaway entry
+func AuditFrom(ctx context.Context) *models.AuditEntry {
+	if entry, ok := ctx.Value(auditKey{}).(*models.AuditEntry); ok {
+		return entry
+	}
+	return &models.AuditEntry{Params: map[string]interface{}{}}
+}
+
+// Address the request came from, without the port
+func SourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Records an audit entry, failing to do so is logged but does not fail the request
+func RecordAudit(db *sql.DB, entry models.AuditEntry) {
+	chartQuery := chartQueries.ChartQueries{}
+
+	if _, err := chartQuery.AddAuditEntry(db, entry); err != nil {
+		log.Printf("Unable to record audit entry %s by %s: %-v\n", entry.Action, entry.Actor, err)
+	}
+}
+
+// Copy of a value with the values of sensitive keys redacted, maps and slices are walked
+func Redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			if sensitiveKey.MatchString(key) {
+				redacted[key] = Redacted
+				continue
+			}
+			redacted[key] = Redact(inner)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, inner := range v {
+			redacted[i] = Redact(inner)
+		}
+		return redacted
+	case []string:
+		redacted := make([]interface{}, len(v))
+		for i, inner := range v {
+			redacted[i] = RedactURL(inner)
+		}
+		return redacted
+	case string:
+		return RedactURL(v)
+	}
+	return value
+}
+
+// Copy of --set style values (a=1,b.password=x) with the values of sensitive keys redacted
+func RedactSetValues(vars []string) []string {
+	return RedactSetValuesWith(vars, nil)
+}
+
+// Copy of --set style values with the values of sensitive keys and of the given secret paths redacted
+func RedactSetValuesWith(vars []string, secrets map[string]bool) []string {
+	redacted := make([]string, len(vars))
+
+	for i, value := range vars {
+		pairs := splitUnescaped(value, ',')
+		for j, pair := range pairs {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) == 2 && (sensitiveKey.MatchString(kv[0]) || secrets[kv[0]]) {
+				pairs[j] = kv[0] + "=" + Redacted
+			}
+		}
+		redacted[i] = strings.Join(pairs, ",")
+	}
+
+	return redacted
+}
+
+// Copy of a url with the password of its user info masked, other strings are returned as they are
+func RedactURL(value string) string {
+	u, err := url.Parse(value)
+
+	if err != nil || u.User == nil {
+		return value
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return u.String()
+}
+
+// Splits on a separator not escaped with a backslash, the way strvals reads --set values
+func splitUnescaped(value string, sep byte) []string {
+	parts := []string{}
+	start := 0
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, value[start:])
+}
@@ -0,0 +1,311 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication methods an identity may come from
+const (
+	AuthBasic     = "basic"
+	AuthToken     = "token"
+	AuthAnonymous = "anonymous"
+)
+
+// Prefix of every api token, tells them apart from other bearer tokens
+const TokenPrefix = "hlm_"
+
+// Seconds the recorded last use of an api token may lag behind, saves a write on every request
+const tokenTouchInterval = 60
+
+var (
+	// ErrUnauthenticated is returned when a request carries no valid credentials
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrTokenNotFound is returned when an api token does not exist or belongs to someone else
+	ErrTokenNotFound = errors.New("api token not found")
+)
+
+type identityKey struct{}
+
+// Users of the htpasswd file, reloaded whenever the file changes
+var htpasswd struct {
+	sync.Mutex
+	modified time.Time
+	users    map[string]string
+}
+
+// Check if requests have to be authenticated, auth.enabled (HELMER_AUTH=off) turns authentication off
+func AuthEnabled() bool {
+	return config.Get().Auth.Enabled
+}
+
+// Location of the htpasswd file holding the basic auth users, configured by auth.htpasswd (HELMER_HTPASSWD)
+func HtpasswdFile() string {
+	return config.Get().Auth.Htpasswd
+}
+
+// Attaches the authenticated identity to a request context
+func WithIdentity(ctx context.Context, identity models.Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// Identity a request was authenticated as, anonymous when authentication is off
+func IdentityFrom(ctx context.Context) models.Identity {
+	if identity, ok := ctx.Value(identityKey{}).(models.Identity); ok {
+		return identity
+	}
+	return models.Identity{Name: AuthAnonymous, Method: AuthAnonymous, Groups: []string{}}
+}
+
+// Authenticates a request by its Authorization header. Basic credentials are checked against the htpasswd file,
+// bearer tokens against the api tokens, any other bearer token has to be a jwt of the oidc issuer. An api token is
+// also accepted as the password of basic credentials, which is what helm repo add --password sends. Requests without
+// credentials are authenticated by their client certificate when tls verified one.
+func Authenticate(db *sql.DB, r *http.Request) (models.Identity, error) {
+	header := r.Header.Get("Authorization")
+
+	if header == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return certIdentity(r.TLS.VerifiedChains[0][0])
+	}
+
+	if strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		if strings.HasPrefix(token, TokenPrefix) {
+			return authenticateToken(db, token)
+		}
+		return authenticateJWT(token)
+	}
+
+	user, password, ok := r.BasicAuth()
+
+	if !ok {
+		return models.Identity{}, ErrUnauthenticated
+	}
+
+	if strings.HasPrefix(password, TokenPrefix) {
+		return authenticateToken(db, password)
+	}
+
+	return authenticateBasic(user, password)
+}
+
+func authenticateBasic(user string, password string) (models.Identity, error) {
+	users, err := htpasswdUsers()
+
+	if err != nil {
+		log.Printf("Unable to read htpasswd file %s: %-v\n", HtpasswdFile(), err)
+		return models.Identity{}, ErrUnauthenticated
+	}
+
+	hash, ok := users[user]
+
+	if !ok || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return models.Identity{}, errors.Wrap(ErrUnauthenticated, "invalid username or password")
+	}
+
+	return models.Identity{Name: user, Method: AuthBasic, Groups: []string{}}, nil
+}
+
+// Users of the htpasswd file, only bcrypt hashes as written by htpasswd -B are accepted
+func htpasswdUsers() (map[string]string, error) {
+	htpasswd.Lock()
+	defer htpasswd.Unlock()
+
+	if HtpasswdFile() == "" {
+		return map[string]string{}, nil
+	}
+
+	info, err := os.Stat(HtpasswdFile())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if htpasswd.users != nil && info.ModTime().Equal(htpasswd.modified) {
+		return htpasswd.users, nil
+	}
+
+	f, err := os.Open(HtpasswdFile())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	users := map[string]string{}
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], "$2") {
+			log.Printf("Skipping htpasswd entry of %s, only bcrypt hashes are supported\n", parts[0])
+			continue
+		}
+		users[parts[0]] = parts[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	htpasswd.users, htpasswd.modified = users, info.ModTime()
+
+	return users, nil
+}
+
+func authenticateToken(db *sql.DB, secret string) (models.Identity, error) {
+	chartQuery := chartQueries.ChartQueries{}
+
+	token, found, err := chartQuery.GetTokenByHash(db, HashToken(secret))
+
+	if err != nil {
+		return models.Identity{}, err
+	}
+
+	now := time.Now().Unix()
+
+	switch {
+	case !found:
+		return models.Identity{}, errors.Wrap(ErrUnauthenticated, "invalid api token")
+	case token.Revoked != 0:
+		return models.Identity{}, errors.Wrap(ErrUnauthenticated, "api token has been revoked")
+	case token.Expires != 0 && token.Expires <= now:
+		return models.Identity{}, errors.Wrap(ErrUnauthenticated, "api token has expired")
+	}
+
+	// Tokens of basic auth users end with the user, other owners are only known while their credentials are in use
+	if token.OwnerMethod == AuthBasic {
+		users, err := htpasswdUsers()
+
+		if err != nil {
+			log.Printf("Unable to read htpasswd file %s: %-v\n", HtpasswdFile(), err)
+			return models.Identity{}, ErrUnauthenticated
+		}
+
+		if _, ok := users[token.Owner]; !ok {
+			return models.Identity{}, errors.Wrap(ErrUnauthenticated, "owner of the api token no longer exists")
+		}
+	}
+
+	if now-token.LastUsed >= tokenTouchInterval {
+		if err := chartQuery.TouchToken(db, token.ID, now); err != nil {
+			log.Printf("Unable to record the use of api token %d: %-v\n", token.ID, err)
+		}
+	}
+
+	groups := token.OwnerGroups
+
+	if groups == nil {
+		groups = []string{}
+	}
+
+	return models.Identity{Name: token.Owner, Method: AuthToken, Groups: groups, TokenID: token.ID}, nil
+}
+
+// Hash an api token is stored under, tokens are random enough for a plain sha256
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates an api token owned by an identity, the returned token carries the secret which is not stored anywhere. The
+// token keeps the authentication method and groups of the owner, a token created with another token inherits them
+// and never outlives it.
+func CreateToken(db *sql.DB, owner models.Identity, request models.TokenRequest) (models.Token, error) {
+	token := models.Token{Name: request.Name, Owner: owner.Name, OwnerMethod: owner.Method, OwnerGroups: owner.Groups, Created: time.Now().Unix()}
+
+	if request.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(request.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			return token, errors.Errorf("expiresIn %q is not a positive duration", request.ExpiresIn)
+		}
+		token.Expires = time.Now().Add(expiresIn).Unix()
+	}
+
+	if token.OwnerGroups == nil {
+		token.OwnerGroups = []string{}
+	}
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	if owner.Method == AuthToken {
+		parent, found, err := chartQuery.GetTokenByID(db, owner.TokenID)
+
+		if err != nil {
+			return token, err
+		}
+
+		if !found {
+			return token, errors.Wrapf(ErrTokenNotFound, "api token %d of the caller does not exist", owner.TokenID)
+		}
+
+		token.OwnerMethod = parent.OwnerMethod
+		token.Expires = limitExpiry(token.Expires, parent.Expires)
+	}
+
+	random := make([]byte, 32)
+
+	if _, err := rand.Read(random); err != nil {
+		return token, err
+	}
+
+	secret := TokenPrefix + hex.EncodeToString(random)
+
+	token.Prefix = secret[:len(TokenPrefix)+8]
+
+	id, err := chartQuery.AddToken(db, token, HashToken(secret))
+
+	if err != nil {
+		return token, err
+	}
+
+	token.ID, token.Token = id, secret
+
+	return token, nil
+}
+
+// Earliest of two expiries, 0 meaning never
+func limitExpiry(expires int64, limit int64) int64 {
+	if limit != 0 && (expires == 0 || expires > limit) {
+		return limit
+	}
+	return expires
+}
+
+// Revokes an api token of an identity
+func RevokeToken(db *sql.DB, owner models.Identity, id int) error {
+	chartQuery := chartQueries.ChartQueries{}
+
+	revoked, err := chartQuery.RevokeToken(db, id, owner.Name, time.Now().Unix())
+
+	if err != nil {
+		return err
+	}
+
+	if revoked == 0 {
+		return errors.Wrapf(ErrTokenNotFound, "no active api token %d owned by %s", id, owner.Name)
+	}
+
+	return nil
+}
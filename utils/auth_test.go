@@ -0,0 +1,23 @@
+package utils
+
+import "testing"
+
+func TestLimitExpiry(t *testing.T) {
+	cases := []struct {
+		expires int64
+		limit   int64
+		want    int64
+	}{
+		{0, 0, 0},
+		{100, 0, 100},
+		{0, 100, 100},
+		{50, 100, 50},
+		{200, 100, 100},
+	}
+
+	for _, c := range cases {
+		if got := limitExpiry(c.expires, c.limit); got != c.want {
+			t.Errorf("limitExpiry(%d, %d) = %d, want %d", c.expires, c.limit, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/mainak90/helmer/config"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Name of the cookie and of the form field carrying the csrf token, the header is X-CSRF-Token
+const (
+	CSRFCookie = "helmer_csrf"
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// ErrCSRF is returned when a browser request lacks a valid csrf token or comes from a foreign origin
+var ErrCSRF = errors.New("csrf check failed")
+
+// Cross-origin requests browsers are allowed to make, configured by auth.cors
+type CORSPolicy struct {
+	// Origins like https://helmer.example.com, globs like https://*.example.com or * for any, none when empty
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	// Seconds browsers may cache a preflight response
+	MaxAge int
+}
+
+// Cors policy of auth.cors, cross-origin requests are refused unless origins are listed. The config is validated
+// on load.
+func LoadCORSPolicy() CORSPolicy {
+	cors := config.Get().Auth.CORS
+
+	return CORSPolicy{
+		Origins:     cors.Origins,
+		Methods:     cors.Methods,
+		Headers:     cors.Headers,
+		Credentials: cors.Credentials,
+		MaxAge:      cors.MaxAge,
+	}
+}
+
+// Check if an origin may make cross-origin requests, a lone * has to be matched on its own as globs stop at slashes
+func (p CORSPolicy) AllowsOrigin(origin string) bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" && origin != "" {
+			return true
+		}
+		if ok, _ := path.Match(allowed, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a method may be used by cross-origin requests, the simple methods always may
+func (p CORSPolicy) AllowsMethod(method string) bool {
+	if method == "GET" || method == "HEAD" || method == "POST" {
+		return true
+	}
+	return containsFold(p.Methods, method)
+}
+
+// Check if every header of a preflight Access-Control-Request-Headers may be sent
+func (p CORSPolicy) AllowsHeaders(headers string) bool {
+	for _, header := range splitList(headers) {
+		if !containsFold(p.Headers, header) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a request comes from the origin it is served on, by its Origin header
+func SameOrigin(r *http.Request) bool {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && strings.EqualFold(origin.Host, r.Host)
+}
+
+// Check if csrf protection is on, auth.csrf (HELMER_CSRF=off) turns it off
+func CSRFEnabled() bool {
+	return config.Get().Auth.CSRF
+}
+
+// New random csrf token
+func NewCSRFToken() (string, error) {
+	random := make([]byte, 32)
+
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(random), nil
+}
+
+// Checks the csrf token of a request, sent as header or form field, against its csrf cookie
+func CheckCSRF(r *http.Request) error {
+	cookie, err := r.Cookie(CSRFCookie)
+
+	if err != nil || cookie.Value == "" {
+		return errors.Wrap(ErrCSRF, "csrf cookie missing")
+	}
+
+	token := r.Header.Get(CSRFHeader)
+
+	if token == "" {
+		token = r.FormValue(CSRFField)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+		return errors.Wrap(ErrCSRF, "csrf token missing or invalid")
+	}
+
+	return nil
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	policy := CORSPolicy{
+		Origins: []string{"https://helmer.example.com", "https://*.apps.example.com"},
+		Methods: []string{"GET", "POST", "PUT", "DELETE"},
+		Headers: []string{"Content-Type", "Authorization", "X-CSRF-Token"},
+	}
+
+	origins := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://helmer.example.com", true},
+		{"https://ui.apps.example.com", true},
+		{"http://helmer.example.com", false},
+		{"https://evil.com", false},
+		{"https://ui.apps.example.com.evil.com", false},
+		{"", false},
+	}
+
+	for _, c := range origins {
+		if got := policy.AllowsOrigin(c.origin); got != c.allowed {
+			t.Errorf("AllowsOrigin(%q) = %t, want %t", c.origin, got, c.allowed)
+		}
+	}
+
+	if (CORSPolicy{Origins: []string{"*"}}).AllowsOrigin("https://any.example.com") != true {
+		t.Error("* does not allow any origin")
+	}
+
+	methods := []struct {
+		method  string
+		allowed bool
+	}{
+		{"GET", true},
+		{"HEAD", true},
+		{"delete", true},
+		{"PATCH", false},
+	}
+
+	for _, c := range methods {
+		if got := policy.AllowsMethod(c.method); got != c.allowed {
+			t.Errorf("AllowsMethod(%q) = %t, want %t", c.method, got, c.allowed)
+		}
+	}
+
+	headers := []struct {
+		headers string
+		allowed bool
+	}{
+		{"", true},
+		{"content-type, x-csrf-token", true},
+		{"Authorization,X-Custom", false},
+	}
+
+	for _, c := range headers {
+		if got := policy.AllowsHeaders(c.headers); got != c.allowed {
+			t.Errorf("AllowsHeaders(%q) = %t, want %t", c.headers, got, c.allowed)
+		}
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	cases := []struct {
+		origin string
+		same   bool
+	}{
+		{"https://helmer.example.com", true},
+		{"http://HELMER.example.com", true},
+		{"https://helmer.example.com:8443", false},
+		{"https://evil.com", false},
+		{"", false},
+		{"null", false},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "https://helmer.example.com/upload", nil)
+		r.Header.Set("Origin", c.origin)
+
+		if got := SameOrigin(r); got != c.same {
+			t.Errorf("SameOrigin with origin %q = %t, want %t", c.origin, got, c.same)
+		}
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	cases := []struct {
+		name   string
+		cookie string
+		header string
+		field  string
+		valid  bool
+	}{
+		{"header", "abc", "abc", "", true},
+		{"form field", "abc", "", "abc", true},
+		{"header wins over the form field", "abc", "abc", "other", true},
+		{"wrong header", "abc", "abd", "", false},
+		{"no token", "abc", "", "", false},
+		{"no cookie", "", "abc", "", false},
+		{"empty cookie and token", "", "", "", false},
+	}
+
+	for _, c := range cases {
+		form := url.Values{}
+
+		if c.field != "" {
+			form.Set(CSRFField, c.field)
+		}
+
+		r := httptest.NewRequest("POST", "/deployChart", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		if c.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: c.cookie})
+		}
+
+		if c.header != "" {
+			r.Header.Set(CSRFHeader, c.header)
+		}
+
+		err := CheckCSRF(r)
+
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("%s: got error %v, want valid %t", c.name, err, c.valid)
+		}
+
+		if err != nil && errors.Cause(err) != ErrCSRF {
+			t.Errorf("%s: got error %v, want ErrCSRF", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"database/sql"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"log"
+	"strings"
+)
+
+// Handling of dependencies declared in Chart.yaml but missing from the charts/ directory of an upload
+const (
+	// DependencyVendor packages the resolved dependencies into the stored archive
+	DependencyVendor = "vendor"
+	// DependencyDeploy only checks the dependencies at upload, they are resolved again on every deploy
+	DependencyDeploy = "deploy"
+	// DependencyOff leaves dependencies alone, as helmer did before
+	DependencyOff = "off"
+)
+
+// Where a dependency got resolved from
+const (
+	DependencySourceArchive  = "archive"
+	DependencySourceHelmer   = "helmer"
+	DependencySourceUpstream = "upstream"
+)
+
+// ErrUnresolvedDependencies is returned when a declared dependency is neither packaged nor resolvable
+var ErrUnresolvedDependencies = errors.New("unresolvable chart dependencies")
+
+// Mode in use, configured by storage.dependencyMode (HELMER_DEPENDENCY_MODE) and defaulting to vendor
+func DependencyMode() string {
+	switch mode := config.Get().Storage.DependencyMode; mode {
+	case DependencyDeploy, DependencyOff:
+		return mode
+	case "", DependencyVendor:
+		return DependencyVendor
+	default:
+		log.Printf("Unknown dependency mode %s, falling back to %s\n", mode, DependencyVendor)
+		return DependencyVendor
+	}
+}
+
+// Resolves the dependencies declared in Chart.yaml which are not packaged in the charts/ directory and adds them to
+// the chart. Dependencies pointing at helmer itself ("", "@helmer" or "helmer") are looked up in the charts table,
+// dependencies on the url of a configured upstream repository are fetched from it, any other repository falls back to the
+// charts table in case the dependency was uploaded into helmer. The status of every declared dependency is returned,
+// ErrUnresolvedDependencies is returned when any of them could not be resolved.
+func ResolveDependencies(db *sql.DB, charted *chart.Chart) ([]models.DependencyStatus, error) {
+	statuses := []models.DependencyStatus{}
+
+	packaged := map[string]*chart.Chart{}
+
+	for _, dep := range charted.Dependencies() {
+		packaged[dep.Name()] = dep
+	}
+
+	unresolved := 0
+
+	for _, dep := range charted.Metadata.Dependencies {
+		status := models.DependencyStatus{Name: dep.Name, Version: dep.Version, Repository: dep.Repository}
+
+		if p, ok := packaged[dep.Name]; ok {
+			status.Resolved = p.Metadata.Version
+			status.Source = DependencySourceArchive
+			statuses = append(statuses, status)
+			continue
+		}
+
+		resolved, source, err := resolveDependency(db, dep)
+
+		if err != nil {
+			status.Error = err.Error()
+			unresolved++
+			statuses = append(statuses, status)
+			continue
+		}
+
+		status.Resolved = resolved.Metadata.Version
+		status.Source = source
+
+		charted.AddDependency(resolved)
+		packaged[dep.Name] = resolved
+
+		statuses = append(statuses, status)
+	}
+
+	if unresolved > 0 {
+		return statuses, errors.Wrapf(ErrUnresolvedDependencies, "%d of %d dependencies of chart %s could not be resolved", unresolved, len(charted.Metadata.Dependencies), charted.Name())
+	}
+
+	return statuses, nil
+}
+
+func resolveDependency(db *sql.DB, dep *chart.Dependency) (*chart.Chart, string, error) {
+	repository := strings.TrimSuffix(dep.Repository, "/")
+
+	switch repository {
+	case "", "@helmer", "helmer":
+		c, err := localDependency(db, dep)
+		return c, DependencySourceHelmer, err
+	}
+
+	for _, upstream := range Upstreams() {
+		if upstream.URL == repository {
+			c, err := upstreamDependency(upstream, dep)
+			return c, DependencySourceUpstream, err
+		}
+	}
+
+	c, err := localDependency(db, dep)
+
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "repository %s is not a configured upstream", dep.Repository)
+	}
+
+	return c, DependencySourceHelmer, nil
+}
+
+// Picks the newest version stored in helmer satisfying the version range of the dependency
+func localDependency(db *sql.DB, dep *chart.Dependency) (*chart.Chart, error) {
+	version := dep.Version
+
+	if version == "" {
+		version = "latest"
+	}
+
+	c, err := ResolveChartVersion(db, dep.Name, version, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return loader.Load(c.Path)
+}
+
+// Fetches the newest version satisfying the version range of the dependency from the index of an upstream repository
+func upstreamDependency(upstream *Upstream, dep *chart.Dependency) (*chart.Chart, error) {
+	index, err := upstream.Index()
+
+	if err != nil {
+		return nil, err
+	}
+
+	cv, err := index.Get(dep.Name, dep.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := upstream.Download(cv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return loader.LoadArchive(resp.Body)
+}
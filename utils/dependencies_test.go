@@ -0,0 +1,295 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Fixture charts table answering the version lookups of ResolveChartVersion, no other query is expected
+type testChartsTable []models.Chart
+
+func (t testChartsTable) Connect(context.Context) (driver.Conn, error) { return t, nil }
+func (t testChartsTable) Driver() driver.Driver                        { return nil }
+func (t testChartsTable) Close() error                                 { return nil }
+func (t testChartsTable) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (t testChartsTable) Prepare(query string) (driver.Stmt, error) {
+	if !strings.HasSuffix(query, " from charts where name=$1") {
+		return nil, errors.Errorf("unexpected query %s", query)
+	}
+	return testChartsStmt{t}, nil
+}
+
+type testChartsStmt struct {
+	table testChartsTable
+}
+
+func (s testChartsStmt) Close() error  { return nil }
+func (s testChartsStmt) NumInput() int { return 1 }
+func (s testChartsStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("the charts table is read only")
+}
+
+func (s testChartsStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &testChartsRows{}
+	for _, c := range s.table {
+		if c.Name == args[0] {
+			rows.charts = append(rows.charts, c)
+		}
+	}
+	return rows, nil
+}
+
+type testChartsRows struct {
+	charts []models.Chart
+}
+
+func (r *testChartsRows) Columns() []string {
+	return []string{"id", "name", "version", "path", "digest", "sourceDigest", "lintStatus", "provenance", "signer", "signerKey",
+		"upstream", "description", "appVersion", "chartType", "keywords", "maintainers"}
+}
+
+func (r *testChartsRows) Close() error { return nil }
+
+func (r *testChartsRows) Next(dest []driver.Value) error {
+	if len(r.charts) == 0 {
+		return io.EOF
+	}
+	c := r.charts[0]
+	r.charts = r.charts[1:]
+	values := []driver.Value{int64(c.ID), c.Name, c.Version, c.Path, c.Digest, c.SourceDigest, c.Lint, c.Provenance,
+		c.Signer, c.SignerKey, c.Upstream, c.Description, c.AppVersion, c.Type, []byte("{}"), []byte("{}")}
+	copy(dest, values)
+	return nil
+}
+
+// Stores the archives of the given versions in dir and returns a database listing them in its charts table
+func testChartsDB(t *testing.T, dir string, name string, versions ...string) *sql.DB {
+	table := testChartsTable{}
+
+	for i, version := range versions {
+		path := filepath.Join(dir, name+"-"+version+".tgz")
+
+		if err := ioutil.WriteFile(path, testChartArchive(t, name, version), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		table = append(table, models.Chart{ID: i + 1, Name: name, Version: version, Path: path})
+	}
+
+	return sql.OpenDB(table)
+}
+
+// Builds a chart declaring the given dependencies, the packaged ones are added to its charts/ directory
+func testDependentChart(dependencies []*chart.Dependency, packaged ...*chart.Chart) *chart.Chart {
+	charted := &chart.Chart{
+		Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "app", Version: "1.0.0", Type: "application", Dependencies: dependencies},
+		Templates: []*chart.File{{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\n")}},
+	}
+
+	for _, p := range packaged {
+		charted.AddDependency(p)
+	}
+
+	return charted
+}
+
+func TestResolveDependencies(t *testing.T) {
+	defer func(list []*Upstream) { upstreams = list }(upstreams)
+
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := testChartsDB(t, dir, "mysql", "1.0.0", "1.2.0", "1.3.0-rc.1", "2.0.0")
+	defer db.Close()
+
+	fixture := newTestRepository()
+	defer fixture.Close()
+
+	fixture.add(t, "nginx", "1.0.0", "/charts/nginx-1.0.0.tgz", "charts/nginx-1.0.0.tgz")
+	fixture.add(t, "nginx", "1.1.0", "/charts/nginx-1.1.0.tgz", "charts/nginx-1.1.0.tgz")
+	fixture.add(t, "nginx", "2.0.0", "/charts/nginx-2.0.0.tgz", "charts/nginx-2.0.0.tgz")
+	// Listed in the index but not served
+	fixture.index.Add(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "nginx", Version: "3.0.0"}, "charts/nginx-3.0.0.tgz", "", "sha256:00")
+
+	upstreams = []*Upstream{testUpstream(t, fixture.URL)}
+
+	packagedMysql := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "mysql", Version: "0.9.0"}}
+
+	cases := []struct {
+		name       string
+		dependency *chart.Dependency
+		packaged   []*chart.Chart
+		resolved   string
+		source     string
+	}{
+		{"packaged", &chart.Dependency{Name: "mysql", Version: "^1.0", Repository: "@helmer"}, []*chart.Chart{packagedMysql}, "0.9.0", DependencySourceArchive},
+		{"helmer", &chart.Dependency{Name: "mysql", Version: "^1.0", Repository: "@helmer"}, nil, "1.2.0", DependencySourceHelmer},
+		{"helmer without repository", &chart.Dependency{Name: "mysql", Version: "~1.0.0"}, nil, "1.0.0", DependencySourceHelmer},
+		{"helmer latest", &chart.Dependency{Name: "mysql", Repository: "helmer"}, nil, "2.0.0", DependencySourceHelmer},
+		{"upstream", &chart.Dependency{Name: "nginx", Version: "^1.0", Repository: fixture.URL + "/"}, nil, "1.1.0", DependencySourceUpstream},
+		// Repositories which are not configured fall back to the charts uploaded into helmer
+		{"unknown repository", &chart.Dependency{Name: "mysql", Version: "^2", Repository: "https://charts.example.com"}, nil, "2.0.0", DependencySourceHelmer},
+		{"unknown chart", &chart.Dependency{Name: "redis", Version: "^1.0", Repository: "@helmer"}, nil, "", ""},
+		{"no matching version", &chart.Dependency{Name: "mysql", Version: "^3", Repository: "@helmer"}, nil, "", ""},
+		{"not in the upstream", &chart.Dependency{Name: "mysql", Version: "^1.0", Repository: fixture.URL}, nil, "", ""},
+		{"upstream download fails", &chart.Dependency{Name: "nginx", Version: "^3", Repository: fixture.URL}, nil, "", ""},
+		{"unknown chart in an unknown repository", &chart.Dependency{Name: "redis", Repository: "https://charts.example.com"}, nil, "", ""},
+	}
+
+	for _, c := range cases {
+		charted := testDependentChart([]*chart.Dependency{c.dependency}, c.packaged...)
+
+		statuses, err := ResolveDependencies(db, charted)
+
+		if len(statuses) != 1 {
+			t.Fatalf("%s: got %d statuses, want 1", c.name, len(statuses))
+		}
+
+		status := statuses[0]
+
+		if status.Name != c.dependency.Name || status.Version != c.dependency.Version || status.Repository != c.dependency.Repository {
+			t.Errorf("%s: got status of %s %s from %s", c.name, status.Name, status.Version, status.Repository)
+		}
+
+		if status.Resolved != c.resolved || status.Source != c.source {
+			t.Errorf("%s: got version %q from %q, want %q from %q", c.name, status.Resolved, status.Source, c.resolved, c.source)
+		}
+
+		if c.resolved == "" {
+			if errors.Cause(err) != ErrUnresolvedDependencies {
+				t.Errorf("%s: got error %v, want ErrUnresolvedDependencies", c.name, err)
+			}
+			if status.Error == "" {
+				t.Errorf("%s: the status has no error", c.name)
+			}
+			if len(charted.Dependencies()) != len(c.packaged) {
+				t.Errorf("%s: got %d dependencies added to the chart", c.name, len(charted.Dependencies())-len(c.packaged))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+
+		if status.Error != "" {
+			t.Errorf("%s: got status error %s", c.name, status.Error)
+		}
+
+		deps := charted.Dependencies()
+
+		if len(deps) != 1 || deps[0].Name() != c.dependency.Name || deps[0].Metadata.Version != c.resolved {
+			t.Errorf("%s: the chart does not hold version %s of %s", c.name, c.resolved, c.dependency.Name)
+		}
+	}
+}
+
+func TestResolveDependenciesPartial(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := testChartsDB(t, dir, "mysql", "1.0.0")
+	defer db.Close()
+
+	charted := testDependentChart([]*chart.Dependency{
+		{Name: "mysql", Version: "1.0.0"},
+		{Name: "redis", Version: "1.0.0"},
+	})
+
+	statuses, err := ResolveDependencies(db, charted)
+
+	if errors.Cause(err) != ErrUnresolvedDependencies {
+		t.Fatalf("got error %v, want ErrUnresolvedDependencies", err)
+	}
+
+	if !strings.Contains(err.Error(), "1 of 2 dependencies") {
+		t.Errorf("got error %v, want it to count the unresolved dependencies", err)
+	}
+
+	// Every declared dependency gets a status, the resolvable ones are still added
+	if len(statuses) != 2 || statuses[0].Resolved != "1.0.0" || statuses[1].Error == "" {
+		t.Errorf("got statuses %+v", statuses)
+	}
+
+	if len(charted.Dependencies()) != 1 {
+		t.Errorf("got %d dependencies added to the chart, want 1", len(charted.Dependencies()))
+	}
+}
+
+func TestVendorDependencies(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := testChartsDB(t, dir, "mysql", "1.0.0")
+	defer db.Close()
+
+	archive := filepath.Join(dir, "app-1.0.0.tgz")
+
+	charted := testDependentChart([]*chart.Dependency{{Name: "mysql", Version: "1.0.0"}})
+
+	if err := ioutil.WriteFile(archive, testChartArchive(t, "app", "1.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded, err := provenance.DigestFile(archive)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ResolveDependencies(db, charted); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := vendorDependencies(charted, archive)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest == uploaded {
+		t.Error("the digest did not change after vendoring")
+	}
+
+	stored, err := provenance.DigestFile(archive)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != stored {
+		t.Errorf("got digest %s, the stored archive has %s", digest, stored)
+	}
+
+	vendored, err := loader.Load(archive)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, dep := range vendored.Dependencies() {
+		names = append(names, dep.Name()+" "+dep.Metadata.Version)
+	}
+
+	if !reflect.DeepEqual(names, []string{"mysql 1.0.0"}) {
+		t.Errorf("got packaged dependencies %v, want mysql 1.0.0", names)
+	}
+}
@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/Masterminds/semver/v3"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Number of loaded chart details kept in memory
+const detailCacheSize = 128
+
+// Same file names helm looks up for 'helm show readme'
+var readmeFileNames = []string{"readme.md", "readme.txt", "readme"}
+
+// Details are keyed by archive path and digest, an overwritten version gets a fresh entry
+var detailCache = struct {
+	sync.Mutex
+	entries map[string]models.ChartDetail
+}{entries: map[string]models.ChartDetail{}}
+
+// Loads the full details of a stored chart version from its archive, cached as archives are immutable for a given digest
+func GetChartDetail(c models.Chart) (models.ChartDetail, error) {
+	key := c.Path + "@" + c.Digest
+
+	detailCache.Lock()
+	detail, ok := detailCache.entries[key]
+	detailCache.Unlock()
+
+	if ok {
+		detail.Chart = c
+		return detail, nil
+	}
+
+	charted, err := loader.Load(c.Path)
+
+	if err != nil {
+		return detail, err
+	}
+
+	detail = chartDetail(charted)
+
+	detailCache.Lock()
+	// Plain eviction of an arbitrary entry is enough for a cache of this size
+	if len(detailCache.entries) >= detailCacheSize {
+		for k := range detailCache.entries {
+			delete(detailCache.entries, k)
+			break
+		}
+	}
+	detailCache.entries[key] = detail
+	detailCache.Unlock()
+
+	detail.Chart = c
+	return detail, nil
+}
+
+func chartDetail(charted *chart.Chart) models.ChartDetail {
+	detail := models.ChartDetail{
+		Metadata:     charted.Metadata,
+		Dependencies: charted.Metadata.Dependencies,
+		Templates:    []string{},
+	}
+
+	for _, f := range charted.Raw {
+		if f.Name == "values.yaml" {
+			detail.Values = string(f.Data)
+		}
+	}
+
+	// A schema which is not valid json would fail the encoding of the whole response, it is left out instead
+	if charted.Schema != nil {
+		if json.Valid(charted.Schema) {
+			detail.Schema = json.RawMessage(charted.Schema)
+		} else {
+			log.Printf("Leaving out the values.schema.json of chart %s version %s, it is not valid json\n", charted.Metadata.Name, charted.Metadata.Version)
+		}
+	}
+
+	for _, f := range charted.Files {
+		for _, n := range readmeFileNames {
+			if strings.EqualFold(f.Name, n) {
+				detail.Readme = string(f.Data)
+			}
+		}
+	}
+
+	for _, t := range charted.Templates {
+		detail.Templates = append(detail.Templates, filepath.ToSlash(t.Name))
+	}
+
+	sort.Strings(detail.Templates)
+
+	return detail
+}
+
+// Sorts chart versions by semver, newest first, versions which do not parse are kept last in lexical order
+func SortChartVersions(charts []models.Chart) {
+	sort.SliceStable(charts, func(i, j int) bool {
+		return versionGreater(charts[i].Version, charts[j].Version)
+	})
+}
+
+func versionGreater(a string, b string) bool {
+	va, erra := semver.NewVersion(a)
+	vb, errb := semver.NewVersion(b)
+	switch {
+	case erra != nil && errb != nil:
+		return a > b
+	case erra != nil:
+		return false
+	case errb != nil:
+		return true
+	}
+	return va.GreaterThan(vb)
+}
+
+// ErrChartNotFound is returned when no stored chart version matches a request
+var ErrChartNotFound = errors.New("chart not found")
+
+// Resolves the version requested for a deploy against the stored versions of the chart. The request may be an
+// exact version, "latest" (or empty) for the newest version, or a semver constraint like "~1.2" or ">=2.0 <3" for
+// the newest version satisfying it. Pre-release versions are only picked by latest and constraints when asked for,
+// an exact version is always honoured.
+func ResolveChartVersion(db *sql.DB, name string, requested string, prerelease bool) (models.Chart, error) {
+	// Names under the prefix of an upstream repository are resolved against its index and cached on demand
+	if upstream, upstreamName := UpstreamFor(name); upstream != nil {
+		c, err := ResolveUpstreamChart(db, upstream, upstreamName, requested, prerelease)
+		if err == nil {
+			return c, nil
+		}
+		log.Printf("Resolving %s against upstream %s failed, falling back to cached versions: %-v\n", name, upstream.Name, err)
+	}
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	charts, err := chartQuery.GetChartVersions(db, name)
+
+	if err != nil {
+		return models.Chart{}, err
+	}
+
+	versions := make([]string, len(charts))
+
+	for i, c := range charts {
+		versions[i] = c.Version
+	}
+
+	if version, ok := PickVersion(versions, requested, prerelease); ok {
+		for _, c := range charts {
+			if c.Version == version {
+				return c, nil
+			}
+		}
+	}
+
+	return models.Chart{}, errors.Wrapf(ErrChartNotFound, "chart %s has no version matching %s", name, requested)
+}
+
+// Picks the version satisfying a request out of the available ones, see ResolveChartVersion for the accepted requests
+func PickVersion(versions []string, requested string, prerelease bool) (string, bool) {
+	for _, v := range versions {
+		if v == requested {
+			return v, true
+		}
+	}
+
+	var constraint *semver.Constraints
+
+	if requested != "" && requested != "latest" {
+		c, err := semver.NewConstraint(requested)
+		if err != nil {
+			return "", false
+		}
+		constraint = c
+	}
+
+	sorted := append([]string{}, versions...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return versionGreater(sorted[i], sorted[j])
+	})
+
+	for _, version := range sorted {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		if v.Prerelease() != "" && !prerelease {
+			continue
+		}
+		if constraint == nil || ConstraintAllows(requested, v, prerelease) {
+			return version, true
+		}
+	}
+
+	return "", false
+}
+
+// Terms of a constraint, the operator and the version it bounds
+var constraintTerm = regexp.MustCompile(`(!=|>=|=>|<=|=<|~>|>|<|=|~|\^)?\s*v?([0-9xX*]+(?:\.[0-9xX*]+)*(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?)`)
+
+// Hyphen ranges of a constraint, 1.2 - 1.4 stands for >=1.2, <=1.4
+var constraintRange = regexp.MustCompile(`(\S+)\s+-\s+(\S+)`)
+
+// Check if a version satisfies a constraint. Semver constraints never match pre-release versions unless they name a
+// pre-release themselves, so when pre-releases are asked for they are checked by semver precedence instead: bounds
+// compare as they are, 1.3.0-rc.1 is lower than 1.3.0 and so matches <1.3.0 but not >=1.3.0. Ranges like ~1.3 or
+// ^1.2 get -0 appended, which takes in the pre-releases within them, but nothing below their lower bound.
+func ConstraintAllows(constraint string, v *semver.Version, prerelease bool) bool {
+	constraints, err := semver.NewConstraint(constraint)
+
+	if err != nil {
+		return false
+	}
+
+	if !prerelease || v.Prerelease() == "" {
+		return constraints.Check(v)
+	}
+
+	for _, group := range strings.Split(constraintRange.ReplaceAllString(constraint, ">=$1, <=$2"), "||") {
+		allowed := true
+
+		for _, term := range constraintTerm.FindAllStringSubmatch(group, -1) {
+			if !termAllows(term[1], term[2], v) {
+				allowed = false
+				break
+			}
+		}
+
+		if allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks a pre-release version against a single term of a constraint
+func termAllows(operator string, version string, v *semver.Version) bool {
+	release := strings.SplitN(version, "+", 2)[0]
+
+	if strings.Contains(release, "-") {
+		c, err := semver.NewConstraint(operator + version)
+		return err == nil && c.Check(v)
+	}
+
+	if strings.Trim(release, "xX*") == "" {
+		return true
+	}
+
+	bound, err := semver.NewVersion(release)
+
+	if err != nil {
+		// Wildcards like 1.x are ranges without a bound to compare to
+		c, err := semver.NewConstraint(operator + release + "-0")
+		return err == nil && c.Check(v)
+	}
+
+	_, strictErr := semver.StrictNewVersion(release)
+	full := strictErr == nil
+
+	switch {
+	case operator == "<":
+		return v.Compare(bound) < 0
+	case operator == ">=" || operator == "=>":
+		return v.Compare(bound) >= 0
+	case full && operator == ">":
+		return v.Compare(bound) > 0
+	case full && (operator == "<=" || operator == "=<"):
+		return v.Compare(bound) <= 0
+	case full && operator == "!=":
+		return v.Compare(bound) != 0
+	case full && (operator == "" || operator == "="):
+		return v.Compare(bound) == 0
+	}
+
+	c, err := semver.NewConstraint(operator + release + "-0")
+
+	if err != nil || !c.Check(v) {
+		return false
+	}
+
+	// Partial upper bounds like <=1.3 take in 1.3.x, the other ranges start at their version
+	switch operator {
+	case "<=", "=<", "!=":
+		return true
+	}
+
+	return v.Compare(bound) >= 0
+}
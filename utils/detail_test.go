@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/json"
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"testing"
+)
+
+func TestChartDetailSchema(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema []byte
+		want   string
+	}{
+		{"valid", []byte(`{"type": "object"}`), `{"type":"object"}`},
+		{"invalid", []byte(`{"type": `), ""},
+		{"missing", nil, ""},
+	}
+
+	for _, c := range cases {
+		charted := &chart.Chart{Metadata: &chart.Metadata{Name: "mysql", Version: "1.0.0"}, Schema: c.schema}
+
+		detail := chartDetail(charted)
+
+		encoded, err := json.Marshal(detail)
+
+		if err != nil {
+			t.Fatalf("%s: encoding failed: %v", c.name, err)
+		}
+
+		var decoded struct {
+			Schema json.RawMessage `json:"schema"`
+		}
+
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(decoded.Schema) != c.want {
+			t.Errorf("%s: got schema %s, want %s", c.name, decoded.Schema, c.want)
+		}
+	}
+}
+
+func TestPickVersion(t *testing.T) {
+	versions := []string{"1.2.0", "1.2.5", "1.3.0-rc.1", "2.0.0", "2.1.0-beta.1", "not-semver"}
+
+	cases := []struct {
+		requested  string
+		prerelease bool
+		version    string
+		found      bool
+	}{
+		{"1.2.0", false, "1.2.0", true},
+		{"not-semver", false, "not-semver", true},
+		{"1.3.0-rc.1", false, "1.3.0-rc.1", true},
+		{"latest", false, "2.0.0", true},
+		{"", false, "2.0.0", true},
+		{"latest", true, "2.1.0-beta.1", true},
+		{"~1.2", false, "1.2.5", true},
+		{"~1.3", false, "", false},
+		{"~1.3", true, "", false},
+		{"~1.2", true, "1.2.5", true},
+		{"<1.3", true, "1.3.0-rc.1", true},
+		{"<1.3", false, "1.2.5", true},
+		{">=2.0 <3", false, "2.0.0", true},
+		{">=2.0 <3", true, "2.1.0-beta.1", true},
+		{"^3", true, "", false},
+		{"not a constraint", false, "", false},
+	}
+
+	for _, c := range cases {
+		version, found := PickVersion(versions, c.requested, c.prerelease)
+		if version != c.version || found != c.found {
+			t.Errorf("%q prerelease %t: got %q %t, want %q %t", c.requested, c.prerelease, version, found, c.version, c.found)
+		}
+	}
+}
+
+func TestConstraintAllows(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		prerelease bool
+		allowed    bool
+	}{
+		{">=1.3.0", "1.3.0", false, true},
+		{">=1.3.0", "1.3.0-rc.1", false, false},
+		{">=1.3.0", "1.3.0-rc.1", true, false},
+		{">=1.3.0", "1.3.1-rc.1", true, true},
+		{">1.2", "1.3.0-rc.1", true, true},
+		{"<2.0.0", "2.0.0-rc.1", false, false},
+		{"<2.0.0", "2.0.0-rc.1", true, true},
+		{"<2.0.0", "2.0.1-rc.1", true, false},
+		{"<=2.0.0", "2.0.0-rc.1", true, true},
+		{"<=1.3", "1.3.5-rc.1", true, true},
+		{"<=1.3", "1.4.0-rc.1", true, false},
+		{"~1.3", "1.3.0-rc.1", true, false},
+		{"~1.3", "1.3.1-rc.1", true, true},
+		{"~1.3", "1.4.0-rc.1", true, false},
+		{"^1.2", "1.9.0-beta.1", true, true},
+		{"^1.2", "2.0.0-beta.1", true, false},
+		{">=1.0 <2", "1.5.0-alpha", true, true},
+		{"1.0 - 2.0.0", "2.0.0-rc.1", true, true},
+		{"1.0 - 2.0.0", "0.9.0-rc.1", true, false},
+		{"<1 || >=3.0.0", "3.0.0-rc.1", true, false},
+		{"<1 || >=3.0.0", "0.9.0-rc.1", true, true},
+		{">=1.3.0-beta", "1.3.0-rc.1", true, true},
+		{">=1.3.0-rc.2", "1.3.0-rc.1", true, false},
+		{"*", "1.3.0-rc.1", true, true},
+		{"!=1.3.0", "1.3.0-rc.1", true, true},
+		{"=1.3.0", "1.3.0-rc.1", true, false},
+	}
+
+	for _, c := range cases {
+		if got := ConstraintAllows(c.constraint, semver.MustParse(c.version), c.prerelease); got != c.allowed {
+			t.Errorf("ConstraintAllows(%q, %s, %t) = %t, want %t", c.constraint, c.version, c.prerelease, got, c.allowed)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"github.com/Masterminds/semver/v3"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrGitImport is returned when a chart cannot be imported from a git repository
+var ErrGitImport = errors.New("unable to import chart from git")
+
+// How long cloning and checking out a repository may take
+var GitTimeout = 5 * time.Minute
+
+// Protocols git may use to clone, ext and the like could run arbitrary commands
+const gitProtocols = "file:git:http:https:ssh"
+
+// Clones a git repository at a ref, packages the chart found under the sub-path the way helm dependency build and
+// helm package would, and stores it the way uploads are. Version and appVersion of the Chart.yaml are overridden
+// when given, the archive is stored with the given options.
+func ImportGitChart(db *sql.DB, source models.GitImport, opts StoreOptions) (StoreResult, error) {
+	var result StoreResult
+
+	if source.URL == "" || strings.HasPrefix(source.URL, "-") || strings.HasPrefix(source.Ref, "-") {
+		return result, errors.Wrap(ErrGitImport, "a repository url is required, url and ref may not start with -")
+	}
+
+	if source.Version != "" {
+		if _, err := semver.StrictNewVersion(source.Version); err != nil {
+			return result, errors.Wrapf(ErrGitImport, "version %s is not a semantic version", source.Version)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "helmer-git-")
+
+	if err != nil {
+		return result, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	clone := filepath.Join(dir, "repo")
+
+	if err := git(dir, "clone", "--quiet", "--no-checkout", "--", source.URL, clone); err != nil {
+		return result, err
+	}
+
+	ref := source.Ref
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if err := git(clone, "checkout", "--quiet", "--detach", ref, "--"); err != nil {
+		return result, err
+	}
+
+	// Nothing is read from the clone before it is known not to point outside of it
+	if err := rejectSymlinks(clone); err != nil {
+		return result, err
+	}
+
+	chartDir, err := insideDir(clone, source.Path)
+
+	if err != nil {
+		return result, err
+	}
+
+	charted, err := loader.LoadDir(chartDir)
+
+	if err != nil {
+		return result, errors.Wrap(ErrInvalidChart, err.Error())
+	}
+
+	if source.Version != "" {
+		charted.Metadata.Version = source.Version
+	}
+
+	if source.AppVersion != "" {
+		charted.Metadata.AppVersion = source.AppVersion
+	}
+
+	if err := localDependencies(clone, chartDir, charted); err != nil {
+		return result, err
+	}
+
+	// Like helm dependency build the remaining dependencies are always packaged, whatever the dependency mode
+	if result.Dependencies, err = ResolveDependencies(db, charted); err != nil {
+		return result, err
+	}
+
+	archive, err := chartutil.Save(charted, dir)
+
+	if err != nil {
+		return result, err
+	}
+
+	f, err := os.Open(archive)
+
+	if err != nil {
+		return result, err
+	}
+
+	defer f.Close()
+
+	log.Printf("Packaged chart %s version %s from %s at %s\n", charted.Name(), charted.Metadata.Version, source.URL, ref)
+
+	return StoreChartWith(db, f, opts)
+}
+
+// Runs a git command in a directory, failures are reported with the output of git
+func git(dir string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), GitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+gitProtocols)
+
+	var output bytes.Buffer
+
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(ErrGitImport, "git %s failed: %s %s", args[0], err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// Refuses a checkout holding symbolic links, the chart loader follows them and could read any file of the server,
+// like the service account token, into the chart
+func rejectSymlinks(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			rel, _ := filepath.Rel(dir, path)
+			return errors.Wrapf(ErrGitImport, "the repository contains the symbolic link %s, charts with symbolic links cannot be imported", filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+}
+
+// Resolves a relative path within a directory, paths escaping it, also through symbolic links, are refused
+func insideDir(dir string, rel string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(rel))
+
+	if !within(dir, path) {
+		return "", errors.Wrapf(ErrGitImport, "path %s is outside of the repository", rel)
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+
+	if err != nil {
+		return "", err
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+
+	if err != nil {
+		return "", errors.Wrapf(ErrGitImport, "path %s does not exist in the repository", rel)
+	}
+
+	if !within(realDir, realPath) {
+		return "", errors.Wrapf(ErrGitImport, "path %s is outside of the repository", rel)
+	}
+
+	return path, nil
+}
+
+// Check if a cleaned path is the directory or below it
+func within(dir string, path string) bool {
+	r, err := filepath.Rel(dir, path)
+	return err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator))
+}
+
+// Packages the dependencies pointing at a file:// path, as helm dependency build does. The paths are relative to
+// the chart and have to stay within the cloned repository.
+func localDependencies(clone string, chartDir string, charted *chart.Chart) error {
+	packaged := map[string]bool{}
+
+	for _, dep := range charted.Dependencies() {
+		packaged[dep.Name()] = true
+	}
+
+	for _, dep := range charted.Metadata.Dependencies {
+		if packaged[dep.Name] || !strings.HasPrefix(dep.Repository, "file://") {
+			continue
+		}
+
+		rel, err := filepath.Rel(clone, filepath.Join(chartDir, strings.TrimPrefix(dep.Repository, "file://")))
+
+		if err != nil {
+			return err
+		}
+
+		depDir, err := insideDir(clone, filepath.ToSlash(rel))
+
+		if err != nil {
+			return err
+		}
+
+		depChart, err := loader.Load(depDir)
+
+		if err != nil {
+			return errors.Wrapf(ErrGitImport, "dependency %s: %s", dep.Name, err)
+		}
+
+		if dep.Version != "" {
+			constraint, err := semver.NewConstraint(dep.Version)
+			if err != nil {
+				return errors.Wrapf(ErrGitImport, "dependency %s has an invalid version range %s", dep.Name, dep.Version)
+			}
+			v, err := semver.NewVersion(depChart.Metadata.Version)
+			if err != nil || !constraint.Check(v) {
+				return errors.Wrapf(ErrGitImport, "dependency %s version %s does not match %s", dep.Name, depChart.Metadata.Version, dep.Version)
+			}
+		}
+
+		charted.AddDependency(depChart)
+		packaged[dep.Name] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func testTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "helmer-test-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestInsideDir(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	outside := testTempDir(t)
+	defer os.RemoveAll(outside)
+
+	os.MkdirAll(filepath.Join(dir, "charts", "app"), os.ModePerm)
+	os.Symlink(outside, filepath.Join(dir, "escape"))
+	os.Symlink(filepath.Join(dir, "charts"), filepath.Join(dir, "local"))
+
+	cases := []struct {
+		rel    string
+		inside bool
+	}{
+		{"", true},
+		{".", true},
+		{"charts/app", true},
+		{"charts/../charts/app", true},
+		{"/charts/app", true},
+		{"local/app", true},
+		{"..", false},
+		{"../" + filepath.Base(outside), false},
+		{"charts/../../x", false},
+		{"escape", false},
+		{"missing", false},
+	}
+
+	for _, c := range cases {
+		_, err := insideDir(dir, c.rel)
+
+		if inside := err == nil; inside != c.inside {
+			t.Errorf("insideDir(%q) returned error %v, want inside %t", c.rel, err, c.inside)
+		}
+	}
+}
+
+func TestRejectSymlinks(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "chart", "templates"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(dir, "chart", "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644)
+
+	if err := rejectSymlinks(dir); err != nil {
+		t.Fatalf("a checkout without symbolic links was refused: %v", err)
+	}
+
+	os.Symlink("/etc/hostname", filepath.Join(dir, "chart", "templates", "README.md"))
+
+	if err := rejectSymlinks(dir); errors.Cause(err) != ErrGitImport {
+		t.Errorf("got error %v for a symbolic link, want ErrGitImport", err)
+	}
+}
+
+// A repository committing a link to a file of the server is refused before the chart is loaded
+func TestImportGitChartSymlink(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "templates"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644)
+	os.Symlink("/etc/hostname", filepath.Join(dir, "README.md"))
+
+	for _, args := range [][]string{{"init", "--quiet"}, {"add", "-A"}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "chart"}} {
+		if err := git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := ImportGitChart(nil, models.GitImport{URL: dir}, StoreOptions{})
+
+	if errors.Cause(err) != ErrGitImport {
+		t.Errorf("got error %v, want ErrGitImport", err)
+	}
+}
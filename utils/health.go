@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Build of the server, set at build time with -ldflags "-X github.com/mainak90/helmer/utils.Version=..."
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Outcomes of a readiness check
+const (
+	HealthOK     = "ok"
+	HealthFailed = "failed"
+)
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context, db *sql.DB) error
+}
+
+// Checks /readyz runs, each one with its own timeout
+var readinessChecks = []readinessCheck{
+	{"database", checkDatabase},
+	{"storage", checkStorage},
+	{"watcher", checkWatcher},
+	{"cluster", checkCluster},
+	{"shutdown", checkShutdown},
+}
+
+// Client of the cluster api used by the cluster check, created on first use
+var clusterClient struct {
+	sync.Mutex
+	clientset kubernetes.Interface
+}
+
+// Build of the running server, the helm version is the one of the helm sdk it was built with
+func BuildVersion() models.VersionInfo {
+	info := models.VersionInfo{
+		Version:     Version,
+		Commit:      Commit,
+		BuildDate:   BuildDate,
+		GoVersion:   runtime.Version(),
+		HelmVersion: "unknown",
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range build.Deps {
+			if dep.Path == "helm.sh/helm/v3" {
+				info.HelmVersion = dep.Version
+			}
+		}
+	}
+
+	return info
+}
+
+// Runs every readiness check at once, each one is failed when it takes longer than health.checkTimeout
+func CheckReadiness(ctx context.Context, db *sql.DB) models.Readiness {
+	timeout := config.Get().Health.CheckTimeout.Duration
+
+	readiness := models.Readiness{Status: HealthOK, Checks: make([]models.HealthCheck, len(readinessChecks))}
+
+	var wg sync.WaitGroup
+
+	for i, c := range readinessChecks {
+		wg.Add(1)
+		go func(i int, c readinessCheck) {
+			defer wg.Done()
+			readiness.Checks[i] = runCheck(ctx, db, c, timeout)
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	for _, check := range readiness.Checks {
+		if check.Status != HealthOK {
+			readiness.Status = HealthFailed
+		}
+	}
+
+	return readiness
+}
+
+// Runs a check, checks which do not give up on their own when the context is done are left behind
+func runCheck(ctx context.Context, db *sql.DB, c readinessCheck, timeout time.Duration) models.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.check(ctx, db)
+	}()
+
+	var err error
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.Errorf("timed out after %s", timeout)
+	}
+
+	result := models.HealthCheck{Name: c.name, Status: HealthOK, Duration: time.Since(started).Milliseconds()}
+
+	if err != nil {
+		result.Status = HealthFailed
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func checkDatabase(ctx context.Context, db *sql.DB) error {
+	return db.PingContext(ctx)
+}
+
+// The storage is writable when a file can be created in it, the watcher only acts on removed archives
+func checkStorage(ctx context.Context, db *sql.DB) error {
+	file, err := ioutil.TempFile(ChartDir, ".readyz-")
+
+	if err != nil {
+		return err
+	}
+
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+func checkWatcher(ctx context.Context, db *sql.DB) error {
+	if !WatcherRunning() {
+		return errors.Errorf("the watcher of %s is not running", ChartDir)
+	}
+	return nil
+}
+
+// The cluster api is reachable when it tells its version
+func checkCluster(ctx context.Context, db *sql.DB) error {
+	clientset, err := clusterClientset()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.Discovery().ServerVersion()
+
+	return err
+}
+
+func checkShutdown(ctx context.Context, db *sql.DB) error {
+	if ShuttingDown() {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+// Client of the cluster helmer deploys to, its requests time out with the readiness checks
+func clusterClientset() (kubernetes.Interface, error) {
+	clusterClient.Lock()
+	defer clusterClient.Unlock()
+
+	if clusterClient.clientset != nil {
+		return clusterClient.clientset, nil
+	}
+
+	actionConfig, err := GetActionConfig("")
+
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig, err := actionConfig.RESTClientGetter.ToRESTConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig = rest.CopyConfig(restConfig)
+	restConfig.Timeout = config.Get().Health.CheckTimeout.Duration
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clusterClient.clientset = clientset
+
+	return clientset, nil
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// States of a deploy record
+const (
+	DeployPending     = "Pending"
+	DeploySuccess     = "Success"
+	DeployFailed      = "Failed"
+	DeployInterrupted = "Interrupted"
+)
+
+// ErrShuttingDown is returned when a job is started after the shutdown began
+var ErrShuttingDown = errors.New("helmer is shutting down")
+
+const (
+	// How often a running instance records its heartbeat and looks for deploys of instances which stopped
+	instanceHeartbeat = 30 * time.Second
+	// Time after its last heartbeat an instance is taken for stopped, its pending deploys for interrupted
+	instanceLease = 3 * instanceHeartbeat
+	// Age after which pending deploys recorded without an instance are taken for stale
+	staleDeployAge = time.Hour
+)
+
+// Name of this run of helmer recorded on its deploys, the hostname with a random suffix as pods of a deployment
+// are renamed on every restart and a restarted process has to tell its deploys from those of the previous run
+var Instance = instanceName()
+
+var stopHeartbeat = make(chan struct{})
+
+func instanceName() string {
+	name, err := os.Hostname()
+
+	if err != nil || name == "" {
+		name = "helmer"
+	}
+
+	random := make([]byte, 4)
+	rand.Read(random)
+
+	return name + "-" + hex.EncodeToString(random)
+}
+
+// Long running work, like installs and mirror syncs, the shutdown waits for
+type Job struct {
+	Name string
+	// Id of the deploy record the job runs, 0 for other jobs
+	Deploy int
+}
+
+// Jobs running, refused once draining began
+var jobs = struct {
+	sync.Mutex
+	running  map[*Job]struct{}
+	draining bool
+	drained  chan struct{}
+}{running: map[*Job]struct{}{}, drained: make(chan struct{})}
+
+// Registers a job, refused with ErrShuttingDown once the shutdown began. Done has to be called when it ends.
+func StartJob(name string) (*Job, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	if jobs.draining {
+		return nil, errors.Wrapf(ErrShuttingDown, "refusing %s", name)
+	}
+
+	job := &Job{Name: name}
+
+	jobs.running[job] = struct{}{}
+
+	return job, nil
+}
+
+// Attaches the deploy record the job runs, marked interrupted when the job does not end in time
+func (j *Job) Record(id int) {
+	jobs.Lock()
+	j.Deploy = id
+	jobs.Unlock()
+}
+
+// Ends the job
+func (j *Job) Done() {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	delete(jobs.running, j)
+
+	if jobs.draining && len(jobs.running) == 0 {
+		close(jobs.drained)
+	}
+}
+
+// Check if the shutdown began
+func ShuttingDown() bool {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	return jobs.draining
+}
+
+// Refuses new jobs and waits for the running ones to end until the context is done. The jobs still running then
+// are returned.
+func DrainJobs(ctx context.Context) []Job {
+	jobs.Lock()
+
+	if !jobs.draining {
+		jobs.draining = true
+		if len(jobs.running) == 0 {
+			close(jobs.drained)
+		}
+	}
+
+	for job := range jobs.running {
+		log.Printf("Waiting for %s\n", job.Name)
+	}
+
+	jobs.Unlock()
+
+	select {
+	case <-jobs.drained:
+	case <-ctx.Done():
+	}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	left := []Job{}
+
+	for job := range jobs.running {
+		left = append(left, *job)
+	}
+
+	return left
+}
+
+// Marks the deploys of jobs cut off by the shutdown as interrupted
+func MarkInterrupted(db *sql.DB, left []Job) {
+	ids := []int{}
+
+	for _, job := range left {
+		log.Printf("Interrupted %s\n", job.Name)
+		if job.Deploy != 0 {
+			ids = append(ids, job.Deploy)
+		}
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	if _, err := chartQuery.MarkDeploysInterrupted(db, ids); err != nil {
+		log.Printf("Failed to mark deploys %v as interrupted: %-v\n", ids, err)
+	}
+}
+
+// Records the heartbeat of this instance and marks the pending deploys of instances whose heartbeat lapsed as
+// interrupted, they stopped before recording their outcome. Runs right away and every instanceHeartbeat until
+// StopHeartbeat.
+func StartHeartbeat(db *sql.DB) {
+	heartbeat(db)
+
+	go func() {
+		ticker := time.NewTicker(instanceHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				heartbeat(db)
+			case <-stopHeartbeat:
+				return
+			}
+		}
+	}()
+}
+
+// Ends the heartbeats, the deploys still pending are taken for interrupted by the other instances after instanceLease
+func StopHeartbeat() {
+	close(stopHeartbeat)
+}
+
+func heartbeat(db *sql.DB) {
+	chartQuery := chartQueries.ChartQueries{}
+
+	now := time.Now()
+
+	if err := chartQuery.RecordHeartbeat(db, Instance, now.Unix()); err != nil {
+		log.Printf("Failed to record the heartbeat of instance %s: %-v\n", Instance, err)
+		return
+	}
+
+	marked, err := chartQuery.MarkStaleDeploysInterrupted(db, now.Add(-instanceLease).Unix(), now.Add(-staleDeployAge).Unix())
+
+	if err != nil {
+		log.Printf("Failed to mark pending deploys as interrupted: %-v\n", err)
+		return
+	}
+
+	if marked > 0 {
+		log.Printf("Marked %d deploys left pending by stopped instances as interrupted\n", marked)
+	}
+}
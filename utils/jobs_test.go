@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainJobs(t *testing.T) {
+	defer func() {
+		jobs.Lock()
+		jobs.running, jobs.draining, jobs.drained = map[*Job]struct{}{}, false, make(chan struct{})
+		jobs.Unlock()
+	}()
+
+	quick, err := StartJob("quick job")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow, err := StartJob("slow install")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow.Record(42)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		quick.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	left := DrainJobs(ctx)
+
+	if len(left) != 1 || left[0].Name != "slow install" || left[0].Deploy != 42 {
+		t.Errorf("got jobs %+v left, want the slow install of deploy 42", left)
+	}
+
+	if !ShuttingDown() {
+		t.Error("not shutting down once draining began")
+	}
+
+	if _, err := StartJob("late job"); errors.Cause(err) != ErrShuttingDown {
+		t.Errorf("got error %v starting a job while draining, want ErrShuttingDown", err)
+	}
+
+	// The last job ending releases drains still waiting
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		slow.Done()
+	}()
+
+	if left := DrainJobs(context.Background()); len(left) != 0 {
+		t.Errorf("got jobs %+v left after every job ended", left)
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	first, second := instanceName(), instanceName()
+
+	if first == second {
+		t.Errorf("two runs got the same instance name %s", first)
+	}
+
+	if hostname != "" && !strings.HasPrefix(first, hostname+"-") {
+		t.Errorf("instance name %s does not start with the hostname %s", first, hostname)
+	}
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/lint"
+	"helm.sh/helm/v3/pkg/lint/support"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Strictness levels of the lint run on every uploaded chart
+const (
+	// LintOff skips linting altogether
+	LintOff = "off"
+	// LintReport lints and stores the report but never rejects an upload
+	LintReport = "report"
+	// LintError rejects uploads with lint errors
+	LintError = "error"
+	// LintWarning rejects uploads with lint errors or warnings, templates are linted in strict mode
+	LintWarning = "warning"
+)
+
+// Lint status of a chart version when no lint message was raised
+const LintOK = "ok"
+
+// ErrLintFailed is returned when the lint strictness refuses an uploaded chart
+var ErrLintFailed = errors.New("chart failed linting")
+
+// Severity names, indexed by the severity constants of the helm lint support package
+var lintSeverities = []string{"unknown", "info", "warning", "error"}
+
+// Strictness in use, configured by storage.lintStrictness (HELMER_LINT_STRICTNESS) and defaulting to report
+func LintStrictness() string {
+	switch strictness := config.Get().Storage.LintStrictness; strictness {
+	case LintOff, LintError, LintWarning:
+		return strictness
+	case "", LintReport:
+		return LintReport
+	default:
+		log.Printf("Unknown lint strictness %s, falling back to %s\n", strictness, LintReport)
+		return LintReport
+	}
+}
+
+// Runs the helm linter against a chart archive, the archive is expanded into a temporary directory as
+// the linter works on chart directories only.
+func LintChart(archive string, strictness string) (models.LintReport, error) {
+	report := models.LintReport{Status: LintOK, Messages: []models.LintMessage{}}
+
+	if strictness == LintOff {
+		report.Status = ""
+		return report, nil
+	}
+
+	dir, err := ioutil.TempDir("", "helmer-lint-")
+
+	if err != nil {
+		return report, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := chartutil.ExpandFile(dir, archive); err != nil {
+		return report, err
+	}
+
+	// Expanding yields a single directory named after the chart
+	entries, err := ioutil.ReadDir(dir)
+
+	if err != nil || len(entries) != 1 {
+		return report, errors.Errorf("unable to expand chart archive %s", archive)
+	}
+
+	linter := lint.All(filepath.Join(dir, entries[0].Name()), nil, "default", strictness == LintWarning)
+
+	for _, m := range linter.Messages {
+		report.Messages = append(report.Messages, models.LintMessage{
+			Severity: lintSeverities[m.Severity],
+			Path:     m.Path,
+			Message:  m.Err.Error(),
+		})
+	}
+
+	if linter.HighestSeverity > support.UnknownSev {
+		report.Status = lintSeverities[linter.HighestSeverity]
+	}
+
+	return report, nil
+}
+
+// Check if the strictness refuses a chart with the given lint report
+func LintRejects(strictness string, report models.LintReport) bool {
+	switch strictness {
+	case LintError:
+		return report.Status == lintSeverities[support.ErrorSev]
+	case LintWarning:
+		return report.Status == lintSeverities[support.ErrorSev] || report.Status == lintSeverities[support.WarningSev]
+	}
+	return false
+}
@@ -0,0 +1,369 @@
+package utils
+
+import (
+	"database/sql"
+	"github.com/Masterminds/semver/v3"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of a mirror
+const (
+	MirrorIdle    = "idle"
+	MirrorSyncing = "syncing"
+	MirrorOK      = "ok"
+	MirrorFailed  = "failed"
+)
+
+// Interval between two syncs of a mirror when none is configured
+const defaultMirrorInterval = time.Hour
+
+// ErrUnknownMirror is returned when a request names a mirror which is not configured
+var ErrUnknownMirror = errors.New("unknown mirror")
+
+// ErrMirrorSyncing is returned when a sync is requested while the mirror is already syncing
+var ErrMirrorSyncing = errors.New("mirror is already syncing")
+
+// Mirror periodically copies selected charts of a helm repository, or of another helmer instance, into the storage.
+// The source is either a configured upstream, reused with its credentials, or a repository of its own.
+type Mirror struct {
+	Name     string `json:"name"`
+	Upstream string `json:"upstream"`
+	// Repository of its own, used when no upstream is named
+	URL                   string `json:"url"`
+	Username              string `json:"username"`
+	Password              string `json:"password"`
+	CAFile                string `json:"caFile"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify"`
+	PassCredentials       bool   `json:"passCredentials"`
+	// Charts to mirror, a version is mirrored when any rule matches it
+	Charts []MirrorRule `json:"charts"`
+	// Go duration between two syncs, defaults to 1h
+	Interval string `json:"interval"`
+
+	source   *Upstream
+	interval time.Duration
+	mu       sync.Mutex
+	status   models.MirrorStatus
+}
+
+// MirrorRule selects charts by a glob on their name and a semver range on their version
+type MirrorRule struct {
+	Name string `json:"name"`
+	// Semver constraint, every version is mirrored when empty
+	Versions string `json:"versions"`
+
+	constraint *semver.Constraints
+}
+
+var mirrors []*Mirror
+
+// Closed by StopMirrors to end the periodic syncs
+var stopMirrors = make(chan struct{})
+
+func loadMirrors(config RepositoryConfig) error {
+	seen := map[string]bool{}
+
+	for _, m := range config.Mirrors {
+		if !upstreamName.MatchString(m.Name) {
+			return errors.Errorf("mirror name %q is not a lowercase dns label", m.Name)
+		}
+		if seen[m.Name] {
+			return errors.Errorf("mirror %s is configured twice", m.Name)
+		}
+		seen[m.Name] = true
+
+		if m.Upstream != "" {
+			u, err := GetUpstream(m.Upstream)
+			if err != nil {
+				return errors.Wrapf(err, "mirror %s", m.Name)
+			}
+			m.source = u
+		} else {
+			m.source = &Upstream{Name: m.Name, URL: m.URL, Username: m.Username, Password: m.Password, CAFile: m.CAFile, InsecureSkipTLSVerify: m.InsecureSkipTLSVerify, PassCredentials: m.PassCredentials}
+			if err := m.source.connect(); err != nil {
+				return errors.Wrapf(err, "mirror %s", m.Name)
+			}
+		}
+
+		if len(m.Charts) == 0 {
+			return errors.Errorf("mirror %s selects no charts", m.Name)
+		}
+
+		for i, rule := range m.Charts {
+			if _, err := path.Match(rule.Name, ""); err != nil || rule.Name == "" {
+				return errors.Errorf("mirror %s has an invalid chart glob %q", m.Name, rule.Name)
+			}
+			if rule.Versions != "" {
+				constraint, err := semver.NewConstraint(rule.Versions)
+				if err != nil {
+					return errors.Wrapf(err, "mirror %s has an invalid version range %q", m.Name, rule.Versions)
+				}
+				m.Charts[i].constraint = constraint
+			}
+		}
+
+		m.interval = defaultMirrorInterval
+
+		if m.Interval != "" {
+			interval, err := time.ParseDuration(m.Interval)
+			if err != nil || interval <= 0 {
+				return errors.Errorf("mirror %s has an invalid interval %q", m.Name, m.Interval)
+			}
+			m.interval = interval
+		}
+
+		m.status = models.MirrorStatus{Name: m.Name, Source: m.source.URL, State: MirrorIdle}
+
+		log.Printf("Mirroring %d chart rules of %s every %s as mirror %s\n", len(m.Charts), m.source.URL, m.interval, m.Name)
+	}
+
+	mirrors = config.Mirrors
+
+	return nil
+}
+
+// Configured mirrors
+func Mirrors() []*Mirror {
+	return mirrors
+}
+
+// Looks up a configured mirror by name
+func GetMirror(name string) (*Mirror, error) {
+	for _, m := range mirrors {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrUnknownMirror, "mirror %s is not configured", name)
+}
+
+// Starts syncing every mirror right away and then once per interval
+func StartMirrors(db *sql.DB) {
+	for _, m := range mirrors {
+		go func(m *Mirror) {
+			ticker := time.NewTicker(m.interval)
+			defer ticker.Stop()
+			for {
+				if err := m.Sync(db); err != nil && errors.Cause(err) != ErrMirrorSyncing {
+					log.Printf("Sync of mirror %s failed: %-v\n", m.Name, err)
+				}
+				select {
+				case <-ticker.C:
+				case <-stopMirrors:
+					return
+				}
+			}
+		}(m)
+	}
+}
+
+// Ends the periodic syncs, a sync in progress is drained like other jobs
+func StopMirrors() {
+	close(stopMirrors)
+}
+
+// Status of the last sync of the mirror
+func (m *Mirror) Status() models.MirrorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// Check if a chart version is selected by one of the rules of the mirror
+func (m *Mirror) Selects(name string, version string) bool {
+	for _, rule := range m.Charts {
+		if ok, _ := path.Match(rule.Name, name); !ok {
+			continue
+		}
+		if rule.constraint == nil {
+			return true
+		}
+		if v, err := semver.NewVersion(version); err == nil && rule.constraint.Check(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Copies the selected chart versions of the source into the storage. Versions already stored with the digest of
+// the source are skipped, the others are downloaded and stored the way uploads are, verified against the digest of
+// the source index. Mirrored versions which disappeared from the source are kept and reported as retained.
+func (m *Mirror) Sync(db *sql.DB) error {
+	m.mu.Lock()
+
+	if m.status.State == MirrorSyncing {
+		m.mu.Unlock()
+		return errors.Wrapf(ErrMirrorSyncing, "mirror %s", m.Name)
+	}
+
+	job, err := StartJob("sync of mirror " + m.Name)
+
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	defer job.Done()
+
+	m.status.State = MirrorSyncing
+	m.status.Started = time.Now().Unix()
+
+	m.mu.Unlock()
+
+	status := models.MirrorStatus{Name: m.Name, Source: m.source.URL, State: MirrorOK, Started: m.status.Started,
+		Synced: []models.MirrorVersion{}, Failed: []models.MirrorVersion{}, Retained: []models.MirrorVersion{}, Conflicts: []models.MirrorVersion{}}
+
+	err = m.sync(db, &status)
+
+	if err != nil {
+		status.State = MirrorFailed
+		status.Error = err.Error()
+	} else if len(status.Failed) > 0 {
+		status.State = MirrorFailed
+		status.Error = "some chart versions failed to sync"
+	}
+
+	status.Finished = time.Now().Unix()
+	status.NextSync = time.Unix(status.Started, 0).Add(m.interval).Unix()
+
+	log.Printf("Mirror %s synced %d versions, %d unchanged, %d failed, %d retained, %d conflicting\n", m.Name, len(status.Synced), status.Unchanged, len(status.Failed), len(status.Retained), len(status.Conflicts))
+
+	m.mu.Lock()
+	m.status = status
+	m.mu.Unlock()
+
+	return err
+}
+
+func (m *Mirror) sync(db *sql.DB, status *models.MirrorStatus) error {
+	index, err := m.source.Index()
+
+	if err != nil {
+		return err
+	}
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	upstream := map[string]bool{}
+
+	names := make([]string, 0, len(index.Entries))
+
+	for name := range index.Entries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, cv := range index.Entries[name] {
+			if !m.Selects(name, cv.Version) {
+				continue
+			}
+
+			upstream[name+"@"+cv.Version] = true
+			status.Matched++
+
+			stored, found, err := chartQuery.GetChartVersion(db, name, cv.Version)
+
+			if err != nil {
+				return err
+			}
+
+			if found && cv.Digest != "" && FileExists(stored.Path) && sameDigest(cv.Digest, stored) {
+				status.Unchanged++
+				continue
+			}
+
+			if found && stored.Upstream != m.Name {
+				status.Conflicts = append(status.Conflicts, models.MirrorVersion{Name: name, Version: cv.Version, Error: conflictSource(stored)})
+				continue
+			}
+
+			// Without a digest in the source index the archive has to be fetched to tell whether it changed
+			unchanged, err := m.fetch(db, name, cv.Version)
+
+			if err != nil {
+				log.Printf("Mirror %s failed to sync chart %s version %s: %-v\n", m.Name, name, cv.Version, err)
+				status.Failed = append(status.Failed, models.MirrorVersion{Name: name, Version: cv.Version, Error: err.Error()})
+				continue
+			}
+
+			if unchanged {
+				status.Unchanged++
+				continue
+			}
+
+			status.Synced = append(status.Synced, models.MirrorVersion{Name: name, Version: cv.Version})
+		}
+	}
+
+	mirrored, err := chartQuery.GetUpstreamCharts(db, m.Name)
+
+	if err != nil {
+		return err
+	}
+
+	for _, c := range mirrored {
+		if !upstream[c.Name+"@"+c.Version] {
+			status.Retained = append(status.Retained, models.MirrorVersion{Name: c.Name, Version: c.Version})
+		}
+	}
+
+	if len(status.Synced) > 0 {
+		if err := GenerateIndex(db); err != nil {
+			log.Printf("Failed to regenerate the repository index: %-v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// Downloads and stores a chart version of the source, tells whether the stored archive already had its digest
+func (m *Mirror) fetch(db *sql.DB, name string, version string) (bool, error) {
+	index, err := m.source.Index()
+
+	if err != nil {
+		return false, err
+	}
+
+	cv, err := index.Get(name, version)
+
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := m.source.Download(cv)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+
+	// Stored under the name the source lists it under, charts of another helmer may have a prefixed name
+	result, err := StoreChartWith(db, resp.Body, StoreOptions{Name: name, Upstream: m.Name, Digest: cv.Digest})
+
+	return result.Unchanged, err
+}
+
+// Describes where a chart version a mirror does not own was stored from
+func conflictSource(stored models.Chart) string {
+	if stored.Upstream == "" {
+		return "stored by an upload"
+	}
+	return "stored from upstream " + stored.Upstream
+}
+
+// Check if a digest of a source index matches the archive stored for a chart version
+func sameDigest(digest string, stored models.Chart) bool {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	return strings.EqualFold(digest, stored.Digest) || strings.EqualFold(digest, stored.SourceDigest)
+}
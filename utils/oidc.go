@@ -0,0 +1,417 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identity method of callers authenticated by a jwt of the oidc issuer
+const AuthOIDC = "oidc"
+
+// Clock skew tolerated on the exp, nbf and iat claims
+const jwtLeeway = time.Minute
+
+// How long fetched signing keys are used before they are fetched again, unknown key ids trigger an earlier
+// fetch. Fetches are attempted at most once per jwksMinRefresh once keys were loaded.
+const (
+	jwksTTL        = time.Hour
+	jwksMinRefresh = time.Minute
+)
+
+// Signature algorithms accepted on jwts, symmetric ones are left out as the keys come from a public jwks
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// Signing keys of the issuer by key id, with the time of the last fetch attempt and the channel closed once the
+// running fetch ends
+var jwks struct {
+	sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+	attempted time.Time
+	fetching  chan struct{}
+}
+
+var jwksClient = &http.Client{Timeout: 30 * time.Second}
+
+// Issuer jwts are accepted from, configured by auth.oidc.issuer (HELMER_OIDC_ISSUER), jwt authentication is off when empty
+func OIDCIssuer() string {
+	return strings.TrimSuffix(config.Get().Auth.OIDC.Issuer, "/")
+}
+
+// Audience jwts have to be issued for, configured by auth.oidc.audience (HELMER_OIDC_AUDIENCE), not checked when empty
+func OIDCAudience() string {
+	return config.Get().Auth.OIDC.Audience
+}
+
+// Claim holding the name of the caller, configured by auth.oidc.usernameClaim (HELMER_OIDC_USERNAME_CLAIM) and
+// defaulting to sub
+func OIDCUsernameClaim() string {
+	if claim := config.Get().Auth.OIDC.UsernameClaim; claim != "" {
+		return claim
+	}
+	return "sub"
+}
+
+// Prefix given to the names of oidc callers, configured by auth.oidc.usernamePrefix (HELMER_OIDC_USERNAME_PREFIX),
+// keeps them apart from basic auth users of the same name
+func OIDCUsernamePrefix() string {
+	return config.Get().Auth.OIDC.UsernamePrefix
+}
+
+// Claim holding the groups of the caller, configured by auth.oidc.groupsClaim (HELMER_OIDC_GROUPS_CLAIM) and
+// defaulting to groups
+func OIDCGroupsClaim() string {
+	if claim := config.Get().Auth.OIDC.GroupsClaim; claim != "" {
+		return claim
+	}
+	return "groups"
+}
+
+// Validates a jwt against the signing keys of the issuer and maps its claims to an identity
+func authenticateJWT(token string) (models.Identity, error) {
+	if OIDCIssuer() == "" {
+		return models.Identity{}, errors.Wrap(ErrUnauthenticated, "unknown bearer token")
+	}
+
+	claims, err := verifyJWT(token)
+
+	if err != nil {
+		log.Printf("Rejected jwt: %-v\n", err)
+		return models.Identity{}, errors.Wrap(ErrUnauthenticated, "invalid jwt")
+	}
+
+	name, _ := claims[OIDCUsernameClaim()].(string)
+
+	if name == "" {
+		return models.Identity{}, errors.Wrapf(ErrUnauthenticated, "jwt has no %s claim", OIDCUsernameClaim())
+	}
+
+	groups := []string{}
+
+	switch g := claims[OIDCGroupsClaim()].(type) {
+	case []interface{}:
+		for _, group := range g {
+			if s, ok := group.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = append(groups, g)
+	}
+
+	return models.Identity{Name: OIDCUsernamePrefix() + name, Method: AuthOIDC, Groups: groups}, nil
+}
+
+// Checks the signature and the registered claims of a jwt and returns its claims
+func verifyJWT(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Wrap(err, "malformed jwt header")
+	}
+
+	hash, ok := jwtAlgorithms[header.Alg]
+
+	if !ok {
+		return nil, errors.Errorf("unsupported jwt algorithm %s", header.Alg)
+	}
+
+	key, err := signingKey(header.Kid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed jwt signature")
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	if err := verifySignature(header.Alg, key, hash, digest, signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Wrap(err, "malformed jwt claims")
+	}
+
+	return claims, checkClaims(claims)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, hash crypto.Hash, digest []byte, signature []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") {
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		}
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(k, hash, digest, signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+			return errors.New("invalid jwt signature")
+		}
+	}
+	return errors.Errorf("jwt algorithm %s does not match its signing key", alg)
+}
+
+func checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != OIDCIssuer() {
+		return errors.Errorf("jwt issued by %s", iss)
+	}
+
+	exp, ok := claims["exp"].(float64)
+
+	if !ok {
+		return errors.New("jwt has no expiry")
+	}
+
+	if now.Add(-jwtLeeway).After(time.Unix(int64(exp), 0)) {
+		return errors.New("jwt has expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("jwt is not valid yet")
+	}
+
+	if iat, ok := claims["iat"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(iat), 0)) {
+		return errors.New("jwt is issued in the future")
+	}
+
+	if OIDCAudience() == "" {
+		return nil
+	}
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == OIDCAudience() {
+			return nil
+		}
+	case []interface{}:
+		for _, a := range aud {
+			if a == OIDCAudience() {
+				return nil
+			}
+		}
+	}
+
+	return errors.Errorf("jwt is not issued for %s", OIDCAudience())
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Signing key of the issuer by key id, the keys are fetched again when the id is unknown or they are too old.
+// A token without key id is accepted when the issuer has a single key. The keys are fetched outside of the lock,
+// callers knowing their key keep using it meanwhile and the others wait for the fetch.
+func signingKey(kid string) (crypto.PublicKey, error) {
+	jwks.Lock()
+	defer jwks.Unlock()
+
+	key, found := lookupKey(kid)
+
+	refresh := jwks.keys == nil || ((!found || time.Since(jwks.fetched) > jwksTTL) && time.Since(jwks.attempted) > jwksMinRefresh)
+
+	if refresh && jwks.fetching == nil {
+		fetching := make(chan struct{})
+		jwks.fetching, jwks.attempted = fetching, time.Now()
+
+		jwks.Unlock()
+		keys, err := loadJWKS()
+		jwks.Lock()
+
+		if err != nil {
+			log.Printf("Unable to load the signing keys of %s: %-v\n", OIDCIssuer(), err)
+		} else {
+			jwks.keys, jwks.fetched = keys, time.Now()
+		}
+
+		jwks.fetching = nil
+		close(fetching)
+
+		key, found = lookupKey(kid)
+	} else if !found && jwks.fetching != nil {
+		fetching := jwks.fetching
+
+		jwks.Unlock()
+		<-fetching
+		jwks.Lock()
+
+		key, found = lookupKey(kid)
+	}
+
+	if !found {
+		return nil, errors.Errorf("unknown jwt signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// Key of the current signing keys by id, the lock has to be held
+func lookupKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := jwks.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(jwks.keys) == 1 {
+		for _, key := range jwks.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Loads the signing keys from auth.oidc.jwksFile (HELMER_OIDC_JWKS_FILE) for offline use, from auth.oidc.jwksUrl
+// (HELMER_OIDC_JWKS_URL), or from the jwks_uri of the discovery document of the issuer.
+func loadJWKS() (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+
+	if file := config.Get().Auth.OIDC.JWKSFile; file != "" {
+		data, err = ioutil.ReadFile(file)
+	} else {
+		jwksURL := config.Get().Auth.OIDC.JWKSURL
+
+		if jwksURL == "" {
+			var discovery struct {
+				JWKSURI string `json:"jwks_uri"`
+			}
+			if err := fetchJSON(OIDCIssuer()+"/.well-known/openid-configuration", &discovery); err != nil {
+				return nil, err
+			}
+			jwksURL = discovery.JWKSURI
+		}
+
+		data, err = fetchBody(jwksURL)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "invalid jwks")
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, errn := base64.RawURLEncoding.DecodeString(k.N)
+			e, erre := base64.RawURLEncoding.DecodeString(k.E)
+			if errn != nil || erre != nil {
+				log.Printf("Skipping malformed rsa key %s\n", k.Kid)
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, ok := curves[k.Crv]
+			x, errx := base64.RawURLEncoding.DecodeString(k.X)
+			y, erry := base64.RawURLEncoding.DecodeString(k.Y)
+			if !ok || errx != nil || erry != nil {
+				log.Printf("Skipping malformed ec key %s\n", k.Kid)
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("jwks holds no usable signing key")
+	}
+
+	return keys, nil
+}
+
+func fetchBody(target string) ([]byte, error) {
+	resp, err := jwksClient.Get(target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetching %s failed with status %s", target, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func fetchJSON(target string, v interface{}) error {
+	data, err := fetchBody(target)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
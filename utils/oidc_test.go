@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mainak90/helmer/config"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testIssuer = "https://issuer.example.com"
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+	}})
+
+	return testKeys{rsa: rsaKey, ec: ecKey, jwks: jwks}
+}
+
+// Signs claims as a jwt, none and HS256 are signed the way an attacker would
+func signJWT(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			// r and s are padded to the curve size
+			signature = make([]byte, 64)
+			rb, sb := r.Bytes(), s.Bytes()
+			copy(signature[32-len(rb):32], rb)
+			copy(signature[64-len(sb):], sb)
+		}
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Points the oidc settings at the issuer and forgets the signing keys, the returned func restores them
+func useTestIssuer(oidc config.OIDC) func() {
+	previous := config.Get().Auth.OIDC
+
+	config.Get().Auth.OIDC = oidc
+
+	resetJWKS()
+
+	return func() {
+		config.Get().Auth.OIDC = previous
+		resetJWKS()
+	}
+}
+
+func resetJWKS() {
+	jwks.Lock()
+	jwks.keys, jwks.fetched, jwks.attempted = nil, time.Time{}, time.Time{}
+	jwks.Unlock()
+}
+
+func testClaims(change func(claims map[string]interface{})) map[string]interface{} {
+	now := time.Now()
+
+	claims := map[string]interface{}{
+		"iss":    testIssuer,
+		"aud":    "helmer",
+		"sub":    "alice",
+		"groups": []string{"dev"},
+		"exp":    now.Add(time.Hour).Unix(),
+		"iat":    now.Unix(),
+	}
+
+	if change != nil {
+		change(claims)
+	}
+
+	return claims
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	keys := newTestKeys(t)
+
+	file, err := ioutil.TempFile("", "helmer-jwks-*.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+
+	file.Write(keys.jwks)
+	file.Close()
+
+	defer useTestIssuer(config.OIDC{Issuer: testIssuer, Audience: "helmer", JWKSFile: file.Name(), UsernamePrefix: "oidc:"})()
+
+	now := time.Now()
+
+	tampered := signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(nil))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	cases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(nil)), true},
+		{"ES256", signJWT(t, "ES256", "ec1", keys.ec, testClaims(nil)), true},
+		{"audience list", signJWT(t, "ES256", "ec1", keys.ec, testClaims(func(c map[string]interface{}) { c["aud"] = []string{"other", "helmer"} })), true},
+		{"issuer with trailing slash", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { c["iss"] = testIssuer + "/" })), true},
+		{"bad signature", tampered, false},
+		{"signed by another key", signJWT(t, "ES256", "ec1", mustECKey(t), testClaims(nil)), false},
+		{"alg none", signJWT(t, "none", "rsa1", nil, testClaims(nil)), false},
+		{"alg HS256 with the public key", signJWT(t, "HS256", "rsa1", keys.rsa.PublicKey.N.Bytes(), testClaims(nil)), false},
+		{"alg of another key type", signJWT(t, "ES256", "rsa1", keys.ec, testClaims(nil)), false},
+		{"expired", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), false},
+		{"expired within leeway", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() })), true},
+		{"no expiry", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { delete(c, "exp") })), false},
+		{"not valid yet", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { c["nbf"] = now.Add(5 * time.Minute).Unix() })), false},
+		{"issued in the future", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { c["iat"] = now.Add(5 * time.Minute).Unix() })), false},
+		{"wrong issuer", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })), false},
+		{"wrong audience", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { c["aud"] = "other" })), false},
+		{"no subject", signJWT(t, "RS256", "rsa1", keys.rsa, testClaims(func(c map[string]interface{}) { delete(c, "sub") })), false},
+		{"unknown key", signJWT(t, "RS256", "rsa2", keys.rsa, testClaims(nil)), false},
+		{"malformed", "not.a.jwt", false},
+	}
+
+	for _, c := range cases {
+		identity, err := authenticateJWT(c.token)
+
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("%s: got error %v, want valid %t", c.name, err, c.valid)
+			continue
+		}
+
+		if c.valid && (identity.Name != "oidc:alice" || identity.Method != AuthOIDC || len(identity.Groups) != 1 || identity.Groups[0] != "dev") {
+			t.Errorf("%s: got identity %+v", c.name, identity)
+		}
+	}
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestSigningKeyRefresh(t *testing.T) {
+	keys := newTestKeys(t)
+
+	var fetches int32
+	var delay atomic.Value
+
+	delay.Store(time.Duration(0))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(delay.Load().(time.Duration))
+		w.Write(keys.jwks)
+	}))
+
+	defer server.Close()
+
+	defer useTestIssuer(config.OIDC{Issuer: testIssuer, JWKSURL: server.URL})()
+
+	if _, err := signingKey("rsa1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown key ids do not fetch again before jwksMinRefresh passed
+	for i := 0; i < 5; i++ {
+		if _, err := signingKey("unknown"); err == nil {
+			t.Fatal("an unknown key id was accepted")
+		}
+	}
+
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("got %d fetches after unknown key ids, want 1", got)
+	}
+
+	jwks.Lock()
+	jwks.attempted = time.Now().Add(-2 * jwksMinRefresh)
+	jwks.Unlock()
+
+	signingKey("unknown")
+	signingKey("unknown")
+
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Errorf("got %d fetches once jwksMinRefresh passed, want 2", got)
+	}
+
+	// A slow refresh of expired keys does not hold up callers whose key is known
+	jwks.Lock()
+	jwks.fetched = time.Now().Add(-2 * jwksTTL)
+	jwks.attempted = jwks.fetched
+	jwks.Unlock()
+
+	delay.Store(500 * time.Millisecond)
+
+	done := make(chan struct{})
+
+	go func() {
+		signingKey("rsa1")
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	started := time.Now()
+
+	if _, err := signingKey("ec1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if waited := time.Since(started); waited > 250*time.Millisecond {
+		t.Errorf("waited %s for the running fetch", waited)
+	}
+
+	<-done
+}
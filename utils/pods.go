@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"github.com/mainak90/helmer/models"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+)
+
+// Resolves the pods of a release, the ones rendered directly in the manifest and the ones selected by
+// the workloads (deployments, statefulsets, daemonsets, jobs...) it owns.
+func GetReleasePods(actionConfig *action.Configuration, rel *release.Release) ([]corev1.Pod, error) {
+	pods := []corev1.Pod{}
+
+	objects, err := getLiveObjects(actionConfig, rel)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := actionConfig.KubernetesClientSet()
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+
+	for _, obj := range objects {
+		var found []corev1.Pod
+
+		switch o := obj.(type) {
+		case *corev1.Pod:
+			found = []corev1.Pod{*o}
+		case *corev1.Service:
+			// Services may select pods of other releases, only workloads are followed
+			continue
+		default:
+			selector, err := kube.SelectorsForObject(obj)
+			if err != nil {
+				continue
+			}
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				continue
+			}
+			list, err := clientset.CoreV1().Pods(accessor.GetNamespace()).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+			if err != nil {
+				return nil, err
+			}
+			found = list.Items
+		}
+
+		for _, pod := range found {
+			key := pod.Namespace + "/" + pod.Name
+			if !seen[key] {
+				seen[key] = true
+				pods = append(pods, pod)
+			}
+		}
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace == pods[j].Namespace {
+			return pods[i].Name < pods[j].Name
+		}
+		return pods[i].Namespace < pods[j].Namespace
+	})
+
+	return pods, nil
+}
+
+// Maps a pod into the summary sent back by the pod listing endpoint
+func PodSummary(pod corev1.Pod) models.Pod {
+	summary := models.Pod{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Phase:     string(pod.Status.Phase),
+		Node:      pod.Spec.NodeName,
+		Created:   pod.CreationTimestamp.Unix(),
+	}
+
+	summary.Ready, summary.Restarts, summary.Status = podReady(&pod)
+
+	for _, c := range pod.Spec.InitContainers {
+		summary.InitContainers = append(summary.InitContainers, c.Name)
+	}
+
+	for _, c := range pod.Spec.Containers {
+		summary.Containers = append(summary.Containers, c.Name)
+	}
+
+	return summary
+}
+
+// Lists the kubernetes events whose involved object is one of the objects owned by the release or one of its pods,
+// most recent events first.
+func GetReleaseEvents(actionConfig *action.Configuration, rel *release.Release, pods []corev1.Pod) ([]models.Event, error) {
+	events := []models.Event{}
+
+	infos, err := actionConfig.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := actionConfig.KubernetesClientSet()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects are keyed by namespace then kind/name, events are listed once per namespace
+	involved := map[string]map[string]bool{}
+
+	involve := func(namespace string, kind string, name string) {
+		if involved[namespace] == nil {
+			involved[namespace] = map[string]bool{}
+		}
+		involved[namespace][kind+"/"+name] = true
+	}
+
+	for _, info := range infos {
+		involve(info.Namespace, info.Mapping.GroupVersionKind.Kind, info.Name)
+	}
+
+	for _, pod := range pods {
+		involve(pod.Namespace, "Pod", pod.Name)
+		for _, owner := range pod.OwnerReferences {
+			involve(pod.Namespace, owner.Kind, owner.Name)
+		}
+	}
+
+	for namespace, objects := range involved {
+		list, err := listEvents(clientset, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			if !objects[e.InvolvedObject.Kind+"/"+e.InvolvedObject.Name] {
+				continue
+			}
+			events = append(events, models.Event{
+				Type:      e.Type,
+				Reason:    e.Reason,
+				Message:   e.Message,
+				Kind:      e.InvolvedObject.Kind,
+				Name:      e.InvolvedObject.Name,
+				Namespace: e.Namespace,
+				Count:     e.Count,
+				FirstSeen: e.FirstTimestamp.Unix(),
+				LastSeen:  e.LastTimestamp.Unix(),
+			})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastSeen > events[j].LastSeen
+	})
+
+	return events, nil
+}
+
+func listEvents(clientset kubernetes.Interface, namespace string) ([]corev1.Event, error) {
+	list, err := clientset.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Builds the objects of the release manifest and refreshes them from the cluster, objects which no longer exist are left out
+func getLiveObjects(actionConfig *action.Configuration, rel *release.Release) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+
+	infos, err := actionConfig.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if err := info.Get(); err != nil {
+			continue
+		}
+		objects = append(objects, kube.AsVersioned(info))
+	}
+
+	return objects, nil
+}
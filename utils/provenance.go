@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"helm.sh/helm/v3/pkg/provenance"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Verification results recorded for every chart version
+const (
+	// ProvenanceNone means no provenance file was uploaded with the chart
+	ProvenanceNone = "none"
+	// ProvenanceVerified means the signature matched a key of the keyring and the archive digest
+	ProvenanceVerified = "verified"
+	// ProvenanceUnknownKey means the chart was signed by a key missing from the keyring
+	ProvenanceUnknownKey = "unknown-key"
+	// ProvenanceInvalid means the provenance file is malformed or does not match the archive
+	ProvenanceInvalid = "invalid"
+	// ProvenanceUnverified means a provenance file exists but no keyring is configured to check it
+	ProvenanceUnverified = "unverified"
+)
+
+var (
+	// ErrUnverifiedChart is returned when a deploy requires a verified chart and the chart is not
+	ErrUnverifiedChart = errors.New("chart is not signed by a trusted key")
+	// ErrProvenanceRejected is returned when a provenance file failing verification would replace a verified one
+	ErrProvenanceRejected = errors.New("provenance file does not verify")
+)
+
+// Keyring holding the public keys trusted to sign charts, configured by storage.keyring (HELMER_KEYRING)
+func Keyring() string {
+	return config.Get().Storage.Keyring
+}
+
+// Location of the provenance file of a chart archive, next to it as helm expects
+func ProvenancePath(archive string) string {
+	return archive + ".prov"
+}
+
+// Stores the provenance file of a stored chart version next to its archive, verifies it against the keyring and
+// records the verification result with the signer on the chart row. A verified provenance file is only replaced by
+// another verified one.
+func StoreProvenance(db *sql.DB, chart models.Chart, prov io.Reader) (models.Chart, error) {
+	// Written and verified as a temporary file first so a failed upload never replaces a valid provenance file
+	tmp, err := ioutil.TempFile(filepath.Dir(chart.Path), ".prov-*")
+
+	if err != nil {
+		return chart, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, prov)
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return chart, err
+	}
+
+	status, signer, key := verifyProvenance(chart.Path, tmp.Name())
+
+	if status != ProvenanceVerified {
+		if current, _, _ := VerifyChart(chart.Path); current == ProvenanceVerified {
+			return chart, errors.Wrapf(ErrProvenanceRejected, "provenance file of chart %s version %s is %s, keeping the verified one", chart.Name, chart.Version, status)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), ProvenancePath(chart.Path)); err != nil {
+		return chart, err
+	}
+
+	os.Chmod(ProvenancePath(chart.Path), 0644)
+
+	chart.Provenance, chart.Signer, chart.SignerKey = status, signer, key
+
+	log.Printf("Provenance of chart %s version %s is %s %s\n", chart.Name, chart.Version, chart.Provenance, chart.Signer)
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	if _, err := chartQuery.UpdateChartProvenance(db, chart); err != nil {
+		return chart, err
+	}
+
+	return chart, nil
+}
+
+// Verifies a chart archive against its provenance file and the keyring, returns the verification result along
+// with the identity and the key fingerprint of the signer when it is known.
+func VerifyChart(archive string) (string, string, string) {
+	if !FileExists(ProvenancePath(archive)) {
+		return ProvenanceNone, "", ""
+	}
+
+	return verifyProvenance(archive, ProvenancePath(archive))
+}
+
+// Verifies a chart archive against the given provenance file and the keyring
+func verifyProvenance(archive string, prov string) (string, string, string) {
+	if Keyring() == "" {
+		return ProvenanceUnverified, "", ""
+	}
+
+	signatory, err := provenance.NewFromKeyring(Keyring(), "")
+
+	if err != nil {
+		log.Printf("Unable to load keyring %s: %-v\n", Keyring(), err)
+		return ProvenanceUnverified, "", ""
+	}
+
+	verification, err := signatory.Verify(archive, prov)
+
+	if errors.Cause(err) == pgperrors.ErrUnknownIssuer {
+		return ProvenanceUnknownKey, "", ""
+	}
+
+	if err != nil {
+		log.Printf("Provenance verification of %s failed: %-v\n", archive, err)
+		return ProvenanceInvalid, "", ""
+	}
+
+	identities := []string{}
+
+	for name := range verification.SignedBy.Identities {
+		identities = append(identities, name)
+	}
+
+	sort.Strings(identities)
+
+	signer := ""
+
+	if len(identities) > 0 {
+		signer = identities[0]
+	}
+
+	return ProvenanceVerified, signer, fmt.Sprintf("%X", verification.SignedBy.PrimaryKey.Fingerprint[:])
+}
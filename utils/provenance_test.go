@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/provenance"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Signs a stored chart archive with a new key, trusted by the keyring written next to it
+func testSignedChart(t *testing.T, dir string) (string, string) {
+	entity, err := openpgp.NewEntity("helmer", "", "helmer@example.com", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := os.Create(filepath.Join(dir, "keyring.gpg"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = entity.Serialize(keyring)
+
+	if cerr := keyring.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "app-1.0.0.tgz")
+
+	if err := ioutil.WriteFile(archive, testChartArchive(t, "app", "1.0.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := (&provenance.Signatory{Entity: entity}).ClearSign(archive)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return archive, signed
+}
+
+func TestStoreProvenanceKeepsVerified(t *testing.T) {
+	defer func(keyring string) { config.Get().Storage.Keyring = keyring }(config.Get().Storage.Keyring)
+
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	archive, signed := testSignedChart(t, dir)
+
+	config.Get().Storage.Keyring = filepath.Join(dir, "keyring.gpg")
+
+	if status, signer, _ := verifyProvenance(archive, writeTestFile(t, dir, "signed.prov", signed)); status != ProvenanceVerified || !strings.Contains(signer, "helmer@example.com") {
+		t.Fatalf("got provenance %s signed by %q, want verified", status, signer)
+	}
+
+	if status, _, _ := verifyProvenance(archive, writeTestFile(t, dir, "garbage.prov", "garbage")); status != ProvenanceInvalid {
+		t.Errorf("got provenance %s for garbage, want invalid", status)
+	}
+
+	if err := ioutil.WriteFile(ProvenancePath(archive), []byte(signed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refused before the chart row is touched
+	_, err := StoreProvenance(nil, models.Chart{Name: "app", Version: "1.0.0", Path: archive}, strings.NewReader("garbage"))
+
+	if errors.Cause(err) != ErrProvenanceRejected {
+		t.Errorf("got error %v, want ErrProvenanceRejected", err)
+	}
+
+	if status, _, _ := VerifyChart(archive); status != ProvenanceVerified {
+		t.Errorf("the stored provenance file is %s after the rejected upload, want verified", status)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".prov-*")); len(matches) != 0 {
+		t.Errorf("temporary provenance files were left behind: %v", matches)
+	}
+}
+
+func writeTestFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
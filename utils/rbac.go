@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"path"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Actions the roles grant
+const (
+	// ActionRead lists and reads charts, releases, mirrors and the repository index
+	ActionRead = "read"
+	// ActionUpload uploads and imports charts and their provenance files
+	ActionUpload = "upload"
+	// ActionDeploy installs, tests and uninstalls releases
+	ActionDeploy = "deploy"
+	// ActionAdmin deletes charts, syncs mirrors and reads the audit log
+	ActionAdmin = "admin"
+)
+
+// Roles which can be bound, with the actions they grant
+var Roles = map[string][]string{
+	"viewer":   {ActionRead},
+	"uploader": {ActionRead, ActionUpload},
+	"deployer": {ActionRead, ActionDeploy},
+	"admin":    {ActionRead, ActionUpload, ActionDeploy, ActionAdmin},
+}
+
+// ErrForbidden is returned when the caller is not allowed to do something
+var ErrForbidden = errors.New("forbidden")
+
+// Binding grants a role to users and groups, scoped by globs on the cluster, the namespace and the chart name.
+// Scopes left out match everything.
+type Binding struct {
+	Role       string   `json:"role"`
+	Users      []string `json:"users"`
+	Groups     []string `json:"groups"`
+	Clusters   []string `json:"clusters"`
+	Namespaces []string `json:"namespaces"`
+	Charts     []string `json:"charts"`
+}
+
+// Authorization file read from HELMER_RBAC
+type RBACConfig struct {
+	// Name of the cluster helmer manages, matched against the cluster scopes, HELMER_CLUSTER takes precedence
+	Cluster  string    `json:"cluster"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// Resource an action is performed on, empty fields are not checked against the scopes unless they always apply
+type Resource struct {
+	Namespace string
+	Chart     string
+	// Set for releases and deploys, which always live in a namespace, an empty namespace then matches no scope
+	Namespaced bool
+}
+
+var rbac *RBACConfig
+
+// Location of the authorization file, configured by auth.rbac (HELMER_RBAC)
+func RBACFile() string {
+	return config.Get().Auth.RBAC
+}
+
+// Name of the cluster helmer manages, configured by cluster.name (HELMER_CLUSTER) and defaulting to default
+func ClusterName() string {
+	if name := config.Get().Cluster.Name; name != "" {
+		return name
+	}
+	if rbac != nil && rbac.Cluster != "" {
+		return rbac.Cluster
+	}
+	return "default"
+}
+
+// Loads the role bindings from the authorization file. Without one every caller is an admin, as before roles existed.
+func LoadRBAC() error {
+	if RBACFile() == "" {
+		log.Println("No authorization file configured, every caller is an admin")
+		rbac = nil
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(RBACFile())
+
+	if err != nil {
+		return err
+	}
+
+	var config RBACConfig
+
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return errors.Wrapf(err, "invalid authorization file %s", RBACFile())
+	}
+
+	for i, b := range config.Bindings {
+		if _, ok := Roles[b.Role]; !ok {
+			return errors.Errorf("binding %d has an unknown role %q", i, b.Role)
+		}
+		for _, glob := range append(append(append([]string{}, b.Clusters...), b.Namespaces...), b.Charts...) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return errors.Errorf("binding %d has an invalid glob %q", i, glob)
+			}
+		}
+	}
+
+	rbac = &config
+
+	log.Printf("Loaded %d role bindings for cluster %s\n", len(config.Bindings), ClusterName())
+
+	return nil
+}
+
+// Check if an identity may perform an action on a resource
+func Allowed(identity models.Identity, action string, resource Resource) bool {
+	if rbac == nil {
+		return true
+	}
+
+	for _, b := range rbac.Bindings {
+		if !bindsTo(b, identity) || !grants(b.Role, action) {
+			continue
+		}
+		if !matchesAny(b.Clusters, ClusterName()) {
+			continue
+		}
+		if (resource.Namespace != "" || resource.Namespaced) && !matchesAny(b.Namespaces, resource.Namespace) {
+			continue
+		}
+		if resource.Chart != "" && !matchesAny(b.Charts, resource.Chart) {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// Returns ErrForbidden when an identity may not perform an action on a resource
+func Authorize(identity models.Identity, action string, resource Resource) error {
+	if Allowed(identity, action, resource) {
+		return nil
+	}
+
+	scope := ""
+
+	if resource.Namespace != "" {
+		scope += " in namespace " + resource.Namespace
+	}
+
+	if resource.Chart != "" {
+		scope += " on chart " + resource.Chart
+	}
+
+	return errors.Wrapf(ErrForbidden, "%s may not %s%s", identity.Name, action, scope)
+}
+
+// Permissions of an identity, one per role binding applying to it
+func GetPermissions(identity models.Identity) models.Permissions {
+	permissions := models.Permissions{Identity: identity, Cluster: ClusterName(), Permissions: []models.Permission{}}
+
+	if rbac == nil {
+		permissions.Permissions = append(permissions.Permissions, models.Permission{
+			Role: "admin", Actions: Roles["admin"], Clusters: []string{"*"}, Namespaces: []string{"*"}, Charts: []string{"*"},
+		})
+		return permissions
+	}
+
+	for _, b := range rbac.Bindings {
+		if !bindsTo(b, identity) || !matchesAny(b.Clusters, ClusterName()) {
+			continue
+		}
+		permissions.Permissions = append(permissions.Permissions, models.Permission{
+			Role: b.Role, Actions: Roles[b.Role], Clusters: orAll(b.Clusters), Namespaces: orAll(b.Namespaces), Charts: orAll(b.Charts),
+		})
+	}
+
+	return permissions
+}
+
+func bindsTo(b Binding, identity models.Identity) bool {
+	for _, user := range b.Users {
+		if user == identity.Name {
+			return true
+		}
+	}
+	for _, group := range b.Groups {
+		for _, g := range identity.Groups {
+			if group == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func grants(role string, action string) bool {
+	for _, a := range Roles[role] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a value matches one of the globs, no globs at all match everything and an empty value nothing else
+func matchesAny(globs []string, value string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	if value == "" {
+		return false
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func orAll(globs []string) []string {
+	if len(globs) == 0 {
+		return []string{"*"}
+	}
+	return globs
+}
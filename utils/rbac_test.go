@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+	cases := []struct {
+		globs []string
+		value string
+		match bool
+	}{
+		{nil, "team-a", true},
+		{nil, "", true},
+		{[]string{"team-a-*"}, "team-a-dev", true},
+		{[]string{"team-a-*"}, "team-b-dev", false},
+		{[]string{"redis", "team-*"}, "redis", true},
+		{[]string{"*"}, "anything", true},
+		{[]string{"*"}, "", false},
+		{[]string{"team-a-*"}, "", false},
+	}
+
+	for _, c := range cases {
+		if got := matchesAny(c.globs, c.value); got != c.match {
+			t.Errorf("matchesAny(%v, %q) = %t, want %t", c.globs, c.value, got, c.match)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	defer func(previous *RBACConfig, cluster string) { rbac, config.Get().Cluster.Name = previous, cluster }(rbac, config.Get().Cluster.Name)
+
+	config.Get().Cluster.Name = ""
+
+	rbac = &RBACConfig{Cluster: "prod", Bindings: []Binding{
+		{Role: "viewer", Groups: []string{"developers"}},
+		{Role: "deployer", Groups: []string{"team-a"}, Namespaces: []string{"team-a-*"}, Charts: []string{"team-a-*", "redis"}},
+		{Role: "uploader", Users: []string{"ci"}, Charts: []string{"team-a-*"}},
+		{Role: "admin", Users: []string{"alice"}, Clusters: []string{"staging"}},
+	}}
+
+	developer := models.Identity{Name: "dev", Groups: []string{"developers"}}
+	teamA := models.Identity{Name: "bob", Groups: []string{"team-a"}}
+	ci := models.Identity{Name: "ci"}
+	alice := models.Identity{Name: "alice"}
+
+	cases := []struct {
+		name     string
+		identity models.Identity
+		action   string
+		resource Resource
+		allowed  bool
+	}{
+		{"viewer reads any chart", developer, ActionRead, Resource{Chart: "nginx"}, true},
+		{"viewer reads any release", developer, ActionRead, Resource{Namespace: "kube-system", Namespaced: true}, true},
+		{"viewer cannot deploy", developer, ActionDeploy, Resource{Namespace: "team-a-dev", Chart: "redis", Namespaced: true}, false},
+		{"deployer in scope", teamA, ActionDeploy, Resource{Namespace: "team-a-dev", Chart: "redis", Namespaced: true}, true},
+		{"deployer outside the namespaces", teamA, ActionDeploy, Resource{Namespace: "default", Chart: "redis", Namespaced: true}, false},
+		{"deployer outside the charts", teamA, ActionDeploy, Resource{Namespace: "team-a-dev", Chart: "nginx", Namespaced: true}, false},
+		{"deployer without namespace", teamA, ActionDeploy, Resource{Chart: "redis", Namespaced: true}, false},
+		{"deployer reads charts of no namespace", teamA, ActionRead, Resource{Chart: "redis"}, true},
+		{"uploader in scope", ci, ActionUpload, Resource{Chart: "team-a-api"}, true},
+		{"uploader outside the charts", ci, ActionUpload, Resource{Chart: "nginx"}, false},
+		{"uploader before the chart is known", ci, ActionUpload, Resource{}, true},
+		{"admin of another cluster", alice, ActionAdmin, Resource{}, false},
+		{"unbound caller", models.Identity{Name: "mallory"}, ActionRead, Resource{}, false},
+	}
+
+	for _, c := range cases {
+		if got := Allowed(c.identity, c.action, c.resource); got != c.allowed {
+			t.Errorf("%s: got %t, want %t", c.name, got, c.allowed)
+		}
+	}
+
+	rbac = nil
+
+	if !Allowed(models.Identity{Name: "anyone"}, ActionAdmin, Resource{}) {
+		t.Error("every caller is an admin without an authorization file")
+	}
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"database/sql"
+	"github.com/Masterminds/semver/v3"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"log"
+	"sort"
+)
+
+// Default and maximum number of charts per page of search results, and the last page that may be asked for
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	MaxPage         = 10000
+)
+
+// ErrInvalidPage is returned when a search asks for a page below 1 or beyond MaxPage
+var ErrInvalidPage = errors.New("invalid page")
+
+// Options of a chart catalog search, empty fields do not filter
+type SearchOptions struct {
+	// Free text matched against name, description and keywords
+	Text string
+	// Semver constraint the chart version must satisfy, e.g. "~1.2" or ">=2.0 <3"
+	Constraint string
+	AppVersion string
+	Type       string
+	Maintainer string
+	// Pre-release versions are left out unless asked for, then they match constraints by semver precedence
+	Prerelease bool
+	// Keeps only the newest matching version of every chart
+	Latest bool
+	// 1 to MaxPage, the first page when 0
+	Page     int
+	PageSize int
+	// Leaves out the charts it returns false for, e.g. the charts the caller may not read
+	Allow func(name string) bool
+}
+
+// Copies the searchable Chart.yaml metadata onto the chart record
+func ApplyMetadata(c *models.Chart, md *chart.Metadata) {
+	c.Description = md.Description
+	c.AppVersion = md.AppVersion
+	c.Type = md.Type
+	if c.Type == "" {
+		c.Type = "application"
+	}
+	c.Keywords = append([]string{}, md.Keywords...)
+	c.Maintainers = []string{}
+	for _, m := range md.Maintainers {
+		if m.Email != "" {
+			c.Maintainers = append(c.Maintainers, m.Name+" <"+m.Email+">")
+		} else {
+			c.Maintainers = append(c.Maintainers, m.Name)
+		}
+	}
+}
+
+// Copies the metadata of charts stored before the search existed from their archive into the table
+func IndexChartMetadata(db *sql.DB) {
+	chartQuery := chartQueries.ChartQueries{}
+
+	charts, err := chartQuery.GetUnindexedCharts(db)
+
+	if err != nil {
+		log.Printf("Unable to fetch charts to index: %-v\n", err)
+		return
+	}
+
+	for _, c := range charts {
+		charted, err := loader.Load(c.Path)
+		if err != nil {
+			log.Printf("Unable to index chart %s version %s: %-v\n", c.Name, c.Version, err)
+			continue
+		}
+		ApplyMetadata(&c, charted.Metadata)
+		if _, err := chartQuery.UpdateChartMetadata(db, c); err != nil {
+			log.Printf("Unable to index chart %s version %s: %-v\n", c.Name, c.Version, err)
+		}
+	}
+
+	if len(charts) > 0 {
+		log.Printf("Indexed metadata of %d charts\n", len(charts))
+	}
+}
+
+// Searches the chart catalog. Text, app version, type and maintainer are filtered in the database, the semver
+// constraint, pre-release exclusion and latest only mode are applied on the parsed versions. Results are ordered
+// by chart name then newest version first, so pages are stable for an unchanged catalog.
+func SearchCharts(db *sql.DB, opts SearchOptions) (models.ChartSearch, error) {
+	search := models.ChartSearch{Page: opts.Page, PageSize: opts.PageSize, Results: []models.Chart{}}
+
+	if search.Page == 0 {
+		search.Page = 1
+	}
+
+	if search.Page < 1 || search.Page > MaxPage {
+		return search, errors.Wrapf(ErrInvalidPage, "page has to be between 1 and %d", MaxPage)
+	}
+
+	if search.PageSize < 1 {
+		search.PageSize = DefaultPageSize
+	}
+
+	if search.PageSize > MaxPageSize {
+		search.PageSize = MaxPageSize
+	}
+
+	if opts.Constraint != "" {
+		if _, err := semver.NewConstraint(opts.Constraint); err != nil {
+			return search, err
+		}
+	}
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	charts, err := chartQuery.SearchCharts(db, opts.Text, opts.AppVersion, opts.Type, opts.Maintainer)
+
+	if err != nil {
+		return search, err
+	}
+
+	return searchPage(charts, opts, search), nil
+}
+
+// Applies the filters of a search which work on the parsed versions to the charts the database matched, and cuts
+// the page out of the sorted results
+func searchPage(charts []models.Chart, opts SearchOptions, search models.ChartSearch) models.ChartSearch {
+	matched := []models.Chart{}
+
+	for _, c := range charts {
+		if opts.Allow != nil && !opts.Allow(c.Name) {
+			continue
+		}
+		v, err := semver.NewVersion(c.Version)
+		if err != nil {
+			// Versions which do not parse cannot satisfy a constraint
+			if opts.Constraint == "" {
+				matched = append(matched, c)
+			}
+			continue
+		}
+		if v.Prerelease() != "" && !opts.Prerelease {
+			continue
+		}
+		if opts.Constraint != "" && !ConstraintAllows(opts.Constraint, v, opts.Prerelease) {
+			continue
+		}
+		matched = append(matched, c)
+	}
+
+	// Rows come ordered by name, versions are sorted within every name
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Name != matched[j].Name {
+			return matched[i].Name < matched[j].Name
+		}
+		return versionGreater(matched[i].Version, matched[j].Version)
+	})
+
+	if opts.Latest {
+		latest := []models.Chart{}
+		for i, c := range matched {
+			if i == 0 || matched[i-1].Name != c.Name {
+				latest = append(latest, c)
+			}
+		}
+		matched = latest
+	}
+
+	search.Total = len(matched)
+
+	start := (search.Page - 1) * search.PageSize
+
+	if start < len(matched) {
+		end := start + search.PageSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		search.Results = matched[start:end]
+	}
+
+	return search
+}
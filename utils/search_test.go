@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"reflect"
+	"testing"
+)
+
+func TestSearchChartsPage(t *testing.T) {
+	// Invalid pages are refused before the database is queried
+	for _, page := range []int{-1, -1 << 62, MaxPage + 1, 1 << 62} {
+		_, err := SearchCharts(nil, SearchOptions{Page: page, PageSize: MaxPageSize})
+		if errors.Cause(err) != ErrInvalidPage {
+			t.Errorf("page %d: got error %v, want ErrInvalidPage", page, err)
+		}
+	}
+}
+
+func TestSearchChartsConstraint(t *testing.T) {
+	// Invalid constraints are refused before the database is queried
+	_, err := SearchCharts(nil, SearchOptions{Constraint: ">=1.0 <"})
+	if err == nil {
+		t.Error("invalid constraint: got no error")
+	}
+}
+
+func TestSearchPage(t *testing.T) {
+	// Rows as the database returns them, ordered by name only
+	charts := []models.Chart{
+		{Name: "redis", Version: "1.0.0"},
+		{Name: "mysql", Version: "1.2.0"},
+		{Name: "mysql", Version: "1.3.0-rc.1"},
+		{Name: "mysql", Version: "not-semver"},
+		{Name: "mysql", Version: "1.10.0"},
+		{Name: "mysql", Version: "2.0.0"},
+		{Name: "nginx", Version: "0.9.0"},
+		{Name: "nginx", Version: "1.0.0-beta.1"},
+		{Name: "redis", Version: "1.1.0"},
+	}
+
+	cases := []struct {
+		name  string
+		opts  SearchOptions
+		total int
+		want  []string
+	}{
+		{"all", SearchOptions{}, 7,
+			[]string{"mysql 2.0.0", "mysql 1.10.0", "mysql 1.2.0", "mysql not-semver", "nginx 0.9.0", "redis 1.1.0", "redis 1.0.0"}},
+		{"prerelease", SearchOptions{Prerelease: true}, 9,
+			[]string{"mysql 2.0.0", "mysql 1.10.0", "mysql 1.3.0-rc.1", "mysql 1.2.0", "mysql not-semver",
+				"nginx 1.0.0-beta.1", "nginx 0.9.0", "redis 1.1.0", "redis 1.0.0"}},
+		{"constraint", SearchOptions{Constraint: "^1.2"}, 2,
+			[]string{"mysql 1.10.0", "mysql 1.2.0"}},
+		{"constraint without prerelease", SearchOptions{Constraint: ">=1.0 <2"}, 4,
+			[]string{"mysql 1.10.0", "mysql 1.2.0", "redis 1.1.0", "redis 1.0.0"}},
+		{"constraint with prerelease", SearchOptions{Constraint: ">=1.0 <2", Prerelease: true}, 5,
+			[]string{"mysql 1.10.0", "mysql 1.3.0-rc.1", "mysql 1.2.0", "redis 1.1.0", "redis 1.0.0"}},
+		{"prerelease below its release", SearchOptions{Constraint: ">=1.0.0", Prerelease: true}, 6,
+			[]string{"mysql 2.0.0", "mysql 1.10.0", "mysql 1.3.0-rc.1", "mysql 1.2.0", "redis 1.1.0", "redis 1.0.0"}},
+		{"latest", SearchOptions{Latest: true}, 3,
+			[]string{"mysql 2.0.0", "nginx 0.9.0", "redis 1.1.0"}},
+		{"latest prerelease", SearchOptions{Latest: true, Prerelease: true}, 3,
+			[]string{"mysql 2.0.0", "nginx 1.0.0-beta.1", "redis 1.1.0"}},
+		{"latest within constraint", SearchOptions{Latest: true, Constraint: "<2"}, 3,
+			[]string{"mysql 1.10.0", "nginx 0.9.0", "redis 1.1.0"}},
+		{"allow", SearchOptions{Allow: func(name string) bool { return name != "mysql" }}, 3,
+			[]string{"nginx 0.9.0", "redis 1.1.0", "redis 1.0.0"}},
+		{"nothing matches", SearchOptions{Constraint: ">=3"}, 0, []string{}},
+	}
+
+	for _, c := range cases {
+		search := searchPage(charts, c.opts, models.ChartSearch{Page: 1, PageSize: DefaultPageSize})
+
+		if search.Total != c.total {
+			t.Errorf("%s: got total %d, want %d", c.name, search.Total, c.total)
+		}
+
+		if got := searchResults(search); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSearchPagePagination(t *testing.T) {
+	charts := []models.Chart{}
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"} {
+		charts = append(charts, models.Chart{Name: "mysql", Version: version})
+	}
+
+	cases := []struct {
+		page     int
+		pageSize int
+		want     []string
+	}{
+		{1, 2, []string{"mysql 1.4.0", "mysql 1.3.0"}},
+		{2, 2, []string{"mysql 1.2.0", "mysql 1.1.0"}},
+		// The last page is partial
+		{3, 2, []string{"mysql 1.0.0"}},
+		// Pages past the end are empty, not an error
+		{4, 2, []string{}},
+		{1, 5, []string{"mysql 1.4.0", "mysql 1.3.0", "mysql 1.2.0", "mysql 1.1.0", "mysql 1.0.0"}},
+		{1, MaxPageSize, []string{"mysql 1.4.0", "mysql 1.3.0", "mysql 1.2.0", "mysql 1.1.0", "mysql 1.0.0"}},
+		{MaxPage, MaxPageSize, []string{}},
+	}
+
+	for _, c := range cases {
+		search := searchPage(charts, SearchOptions{}, models.ChartSearch{Page: c.page, PageSize: c.pageSize})
+
+		if search.Total != len(charts) {
+			t.Errorf("page %d of %d: got total %d, want %d", c.page, c.pageSize, search.Total, len(charts))
+		}
+
+		if search.Page != c.page || search.PageSize != c.pageSize {
+			t.Errorf("page %d of %d: got page %d of %d", c.page, c.pageSize, search.Page, search.PageSize)
+		}
+
+		if got := searchResults(search); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("page %d of %d: got %v, want %v", c.page, c.pageSize, got, c.want)
+		}
+	}
+}
+
+// Lists the results of a search as "name version"
+func searchResults(search models.ChartSearch) []string {
+	results := []string{}
+	for _, c := range search.Results {
+		results = append(results, c.Name+" "+c.Version)
+	}
+	return results
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mainak90/helmer/models"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	helmdriver "helm.sh/helm/v3/pkg/storage/driver"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Readiness states reported for every object owned by a release
+const (
+	ResourceReady       = "Ready"
+	ResourceProgressing = "Progressing"
+	ResourceFailed      = "Failed"
+	ResourceMissing     = "Missing"
+	ResourceUnknown     = "Unknown"
+)
+
+// Aggregated health verdicts of a release
+const (
+	HealthHealthy     = "Healthy"
+	HealthProgressing = "Progressing"
+	HealthDegraded    = "Degraded"
+	HealthUnknown     = "Unknown"
+)
+
+// Check if the error returned by a helm action means the release does not exist at all
+func IsReleaseNotFound(err error) bool {
+	return err == helmdriver.ErrReleaseNotFound
+}
+
+// Enumerates every object rendered in the release manifest and fetches its live readiness from the cluster
+func GetReleaseResources(actionConfig *action.Configuration, rel *release.Release) ([]models.ResourceStatus, error) {
+	resources := []models.ResourceStatus{}
+
+	infos, err := actionConfig.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := actionConfig.KubernetesClientSet()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		resource := models.ResourceStatus{
+			Kind:      info.Mapping.GroupVersionKind.Kind,
+			Name:      info.Name,
+			Namespace: info.Namespace,
+		}
+
+		// Refresh the object from the api server, the manifest only holds the desired state
+		if err := info.Get(); err != nil {
+			if apierrors.IsNotFound(err) {
+				resource.Status = ResourceMissing
+				resource.Message = "object not found in cluster"
+			} else {
+				resource.Status = ResourceUnknown
+				resource.Message = err.Error()
+			}
+			resources = append(resources, resource)
+			continue
+		}
+
+		checkResource(clientset, kube.AsVersioned(info), &resource)
+
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
+
+// Fills in the readiness of a single object based on its kind, kinds without a known readiness
+// notion are considered ready as soon as they exist.
+func checkResource(clientset kubernetes.Interface, obj runtime.Object, resource *models.ResourceStatus) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		desired := replicas(o.Spec.Replicas)
+		resource.Message = fmt.Sprintf("%d/%d replicas available", o.Status.AvailableReplicas, desired)
+		resource.Ready = o.Status.ObservedGeneration >= o.Generation && o.Status.AvailableReplicas >= desired
+		for _, c := range o.Status.Conditions {
+			if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+				resource.Status = ResourceFailed
+				resource.Message = c.Message
+				return
+			}
+		}
+	case *appsv1.StatefulSet:
+		desired := replicas(o.Spec.Replicas)
+		resource.Message = fmt.Sprintf("%d/%d replicas ready", o.Status.ReadyReplicas, desired)
+		resource.Ready = o.Status.ObservedGeneration >= o.Generation && o.Status.ReadyReplicas >= desired
+	case *appsv1.DaemonSet:
+		resource.Message = fmt.Sprintf("%d/%d pods available", o.Status.NumberAvailable, o.Status.DesiredNumberScheduled)
+		resource.Ready = o.Status.NumberAvailable >= o.Status.DesiredNumberScheduled
+	case *corev1.Pod:
+		resource.Ready, resource.Restarts, resource.Message = podReady(o)
+		if o.Status.Phase == corev1.PodFailed || resource.Message == "CrashLoopBackOff" {
+			resource.Status = ResourceFailed
+			return
+		}
+	case *batchv1.Job:
+		completions := replicas(o.Spec.Completions)
+		resource.Message = fmt.Sprintf("%d/%d completions succeeded", o.Status.Succeeded, completions)
+		resource.Ready = o.Status.Succeeded >= completions
+		for _, c := range o.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				resource.Status = ResourceFailed
+				resource.Message = c.Message
+				return
+			}
+		}
+	case *corev1.Service:
+		resource.Ready, resource.Message = serviceReady(clientset, o)
+	case *corev1.PersistentVolumeClaim:
+		resource.Message = string(o.Status.Phase)
+		resource.Ready = o.Status.Phase == corev1.ClaimBound
+		if o.Status.Phase == corev1.ClaimLost {
+			resource.Status = ResourceFailed
+			return
+		}
+	default:
+		resource.Ready = true
+	}
+
+	if resource.Ready {
+		resource.Status = ResourceReady
+	} else {
+		resource.Status = ResourceProgressing
+	}
+}
+
+// Replica style counters default to 1 when left unset in the spec
+func replicas(count *int32) int32 {
+	if count == nil {
+		return 1
+	}
+	return *count
+}
+
+// A pod is ready once all of its containers are ready, or once it ran to completion
+func podReady(pod *corev1.Pod) (bool, int32, string) {
+	var restarts int32
+	message := string(pod.Status.Phase)
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		restarts += cs.RestartCount
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			message = cs.State.Waiting.Reason
+		}
+	}
+
+	if pod.Status.Phase == corev1.PodSucceeded {
+		return true, restarts, message
+	}
+
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+			return true, restarts, message
+		}
+	}
+	return false, restarts, message
+}
+
+// A service is ready once it has at least one ready endpoint address, services that do not
+// select pods (ExternalName or selector-less) are always ready.
+func serviceReady(clientset kubernetes.Interface, svc *corev1.Service) (bool, string) {
+	if svc.Spec.Type == corev1.ServiceTypeExternalName || len(svc.Spec.Selector) == 0 {
+		return true, "no pod selector"
+	}
+
+	endpoints, err := clientset.CoreV1().Endpoints(svc.Namespace).Get(context.Background(), svc.Name, metav1.GetOptions{})
+
+	if err != nil {
+		return false, err.Error()
+	}
+
+	ready, notReady := 0, 0
+	for _, subset := range endpoints.Subsets {
+		ready += len(subset.Addresses)
+		notReady += len(subset.NotReadyAddresses)
+	}
+
+	return ready > 0, fmt.Sprintf("%d ready, %d not ready endpoints", ready, notReady)
+}
+
+// Aggregates the release status and the readiness of its objects into a single health verdict
+func ReleaseHealth(rel *release.Release, resources []models.ResourceStatus) string {
+	switch rel.Info.Status {
+	case release.StatusFailed:
+		return HealthDegraded
+	case release.StatusPendingInstall, release.StatusPendingUpgrade, release.StatusPendingRollback:
+		return HealthProgressing
+	case release.StatusDeployed:
+	default:
+		return HealthUnknown
+	}
+
+	health := HealthHealthy
+	for _, resource := range resources {
+		switch resource.Status {
+		case ResourceFailed, ResourceMissing:
+			return HealthDegraded
+		case ResourceProgressing:
+			health = HealthProgressing
+		case ResourceUnknown:
+			if health == HealthHealthy {
+				health = HealthUnknown
+			}
+		}
+	}
+	return health
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"database/sql"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Root of the chart storage, archives live under <ChartDir>/<name>/<version>/<name>-<version>.tgz. Set from
+// storage.dir (HELMER_STORAGE_DIR) on start.
+var ChartDir = "/tmp/charts"
+
+// Suffix given to archives while their removal is pending, a rename keeps them out of reach of the watcher
+const stagedSuffix = ".deleting"
+
+// Location of the repository index, served as /index.yaml
+func IndexPath() string {
+	return filepath.Join(ChartDir, "index.yaml")
+}
+
+// Regenerates the repository index from the charts recorded in the database, archive urls are relative
+// to the repository root and served under /archives/.
+func GenerateIndex(db *sql.DB) error {
+	var chart models.Chart
+
+	index := repo.NewIndexFile()
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	for _, c := range chartQuery.GetCharts(db, chart, []models.Chart{}) {
+		charted, err := loader.Load(c.Path)
+		if err != nil {
+			log.Printf("Skipping chart %s version %s from the index: %-v\n", c.Name, c.Version, err)
+			continue
+		}
+
+		digest, err := provenance.DigestFile(c.Path)
+		if err != nil {
+			log.Printf("Skipping chart %s version %s from the index: %-v\n", c.Name, c.Version, err)
+			continue
+		}
+
+		rel, err := filepath.Rel(ChartDir, c.Path)
+		if err != nil {
+			log.Printf("Skipping chart %s version %s from the index: %-v\n", c.Name, c.Version, err)
+			continue
+		}
+
+		// Listed under the stored name, which differs from Chart.yaml for charts cached from upstreams
+		md := *charted.Metadata
+		md.Name = c.Name
+
+		index.Add(&md, "archives/"+filepath.ToSlash(rel), "", digest)
+	}
+
+	index.SortEntries()
+
+	if err := os.MkdirAll(ChartDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	return index.WriteFile(IndexPath(), 0644)
+}
+
+// Deletes a chart version, or every version when version is empty, from both the database and the storage.
+// The rows are deleted in a transaction which is only committed once every archive has been staged for removal,
+// staged archives are restored if anything fails so the database and the storage never disagree.
+func DeleteCharts(db *sql.DB, name string, version string) ([]models.Chart, error) {
+	chartQuery := chartQueries.ChartQueries{}
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	charts, err := chartQuery.RemoveChartsTx(tx, name, version)
+
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	staged := []string{}
+
+	restore := func() {
+		for _, path := range staged {
+			if err := os.Rename(path+stagedSuffix, path); err != nil {
+				log.Printf("Failed to restore chart archive %s: %-v\n", path, err)
+			}
+		}
+	}
+
+	for _, c := range charts {
+		err := os.Rename(c.Path, c.Path+stagedSuffix)
+		// The archive may already be gone from the storage, there is nothing to stage then
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			restore()
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "unable to remove chart archive %s", c.Path)
+		}
+		staged = append(staged, c.Path)
+	}
+
+	if err := tx.Commit(); err != nil {
+		restore()
+		return nil, err
+	}
+
+	for _, c := range charts {
+		os.Remove(c.Path + stagedSuffix)
+		os.Remove(ProvenancePath(c.Path))
+		// Drops the version directory, and the chart directory after its last version, when left empty
+		os.Remove(filepath.Dir(c.Path))
+		os.Remove(filepath.Dir(filepath.Dir(c.Path)))
+	}
+
+	return charts, nil
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Outcomes recorded for a run of the release tests
+const (
+	TestPassed  = "Passed"
+	TestFailed  = "Failed"
+	TestSkipped = "Skipped"
+)
+
+// Runs the test hooks of a release the same way 'helm test' does, with the difference that only the hooks
+// selected by the filter are executed and the logs of every test pod are captured before the delete
+// policies of the hooks get a chance to remove them.
+// Filter entries are test names, a name prefixed with '!' excludes that test instead. A failing test is
+// reported in its result and skips the remaining ones, the returned error is kept for tests that could not run.
+func RunReleaseTests(actionConfig *action.Configuration, rel *release.Release, timeout time.Duration, filter []string) ([]models.TestResult, error) {
+	results := []models.TestResult{}
+
+	clientset, err := actionConfig.KubernetesClientSet()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tests := []*release.Hook{}
+
+	for _, h := range rel.Hooks {
+		for _, e := range h.Events {
+			if e == release.HookTest {
+				tests = append(tests, h)
+			}
+		}
+	}
+
+	// Hooks are pre-ordered by kind, keep the order stable within the same weight
+	sort.SliceStable(tests, func(i, j int) bool {
+		if tests[i].Weight == tests[j].Weight {
+			return tests[i].Name < tests[j].Name
+		}
+		return tests[i].Weight < tests[j].Weight
+	})
+
+	executed := []*release.Hook{}
+
+	var runErr error
+
+	for _, h := range tests {
+		result := models.TestResult{Name: h.Name, Kind: h.Kind}
+
+		if !testSelected(h.Name, filter) || runErr != nil {
+			result.Phase = TestSkipped
+			results = append(results, result)
+			continue
+		}
+
+		// Default delete policy is before-hook-creation, same as helm
+		if len(h.DeletePolicies) == 0 {
+			h.DeletePolicies = []release.HookDeletePolicy{release.HookBeforeHookCreation}
+		}
+
+		if err := deleteHookByPolicy(actionConfig, h, release.HookBeforeHookCreation); err != nil {
+			return results, err
+		}
+
+		resources, err := actionConfig.KubeClient.Build(bytes.NewBufferString(h.Manifest), true)
+
+		if err != nil {
+			return results, errors.Wrapf(err, "unable to build kubernetes object for test %s", h.Path)
+		}
+
+		h.LastRun = release.HookExecution{StartedAt: helmtime.Now(), Phase: release.HookPhaseRunning}
+
+		if _, err := actionConfig.KubeClient.Create(resources); err != nil {
+			h.LastRun.CompletedAt = helmtime.Now()
+			h.LastRun.Phase = release.HookPhaseFailed
+			runErr = errors.Wrapf(err, "test %s failed to create", h.Name)
+		} else {
+			executed = append(executed, h)
+			err = actionConfig.KubeClient.WatchUntilReady(resources, timeout)
+			h.LastRun.CompletedAt = helmtime.Now()
+			if err != nil {
+				h.LastRun.Phase = release.HookPhaseFailed
+				runErr = errors.Wrapf(err, "test %s failed", h.Name)
+			} else {
+				h.LastRun.Phase = release.HookPhaseSucceeded
+			}
+		}
+
+		result.Phase = string(h.LastRun.Phase)
+		result.Started = h.LastRun.StartedAt.Unix()
+		result.Completed = h.LastRun.CompletedAt.Unix()
+
+		if runErr != nil {
+			result.Message = runErr.Error()
+		}
+
+		if h.Kind == "Pod" {
+			logs, err := getPodLogs(clientset, rel.Namespace, h.Name)
+			if err != nil {
+				log.Printf("Unable to capture logs of test pod %s: %-v\n", h.Name, err)
+			}
+			result.Logs = logs
+		}
+
+		results = append(results, result)
+	}
+
+	for _, h := range executed {
+		policy := release.HookSucceeded
+		if h.LastRun.Phase == release.HookPhaseFailed {
+			policy = release.HookFailed
+		}
+		if err := deleteHookByPolicy(actionConfig, h, policy); err != nil {
+			log.Printf("Unable to delete test %s: %-v\n", h.Name, err)
+		}
+	}
+
+	if err := actionConfig.Releases.Update(rel); err != nil {
+		log.Printf("Unable to record test results on release %s: %-v\n", rel.Name, err)
+	}
+
+	return results, nil
+}
+
+// Aggregates the individual test results into the outcome recorded for the release. A run where no test succeeded,
+// because the release has no tests or the filter selected none, is skipped rather than passed.
+func TestOutcome(results []models.TestResult) string {
+	passed := false
+
+	for _, result := range results {
+		switch result.Phase {
+		case string(release.HookPhaseSucceeded):
+			passed = true
+		case TestSkipped:
+		default:
+			return TestFailed
+		}
+	}
+
+	if !passed {
+		return TestSkipped
+	}
+
+	return TestPassed
+}
+
+// Check if a test is picked up by the filter, an empty filter selects every test
+func testSelected(name string, filter []string) bool {
+	included := false
+	hasIncludes := false
+
+	for _, f := range filter {
+		if strings.HasPrefix(f, "!") {
+			if strings.TrimPrefix(f, "!") == name {
+				return false
+			}
+			continue
+		}
+		hasIncludes = true
+		if f == name {
+			included = true
+		}
+	}
+	return included || !hasIncludes
+}
+
+// Deletes the hook resources in case the hook carries the given delete policy
+func deleteHookByPolicy(actionConfig *action.Configuration, h *release.Hook, policy release.HookDeletePolicy) error {
+	for _, p := range h.DeletePolicies {
+		if p != policy {
+			continue
+		}
+		resources, err := actionConfig.KubeClient.Build(bytes.NewBufferString(h.Manifest), false)
+		if err != nil {
+			return errors.Wrapf(err, "unable to build kubernetes object for deleting hook %s", h.Path)
+		}
+		if _, errs := actionConfig.KubeClient.Delete(resources); len(errs) > 0 {
+			return errors.Errorf("unable to delete hook %s: %v", h.Name, errs)
+		}
+	}
+	return nil
+}
+
+// Fetches the logs of every container of the pod, prefixed by the container name when there are several
+func getPodLogs(clientset kubernetes.Interface, namespace string, name string) (string, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+
+	for _, c := range pod.Spec.Containers {
+		raw, err := clientset.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Container: c.Name}).DoRaw(context.Background())
+		if err != nil {
+			return out.String(), err
+		}
+		if len(pod.Spec.Containers) > 1 {
+			fmt.Fprintf(&out, "==> %s <==\n", c.Name)
+		}
+		out.Write(raw)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"github.com/mainak90/helmer/models"
+	"testing"
+)
+
+func TestTestOutcome(t *testing.T) {
+	cases := []struct {
+		name    string
+		phases  []string
+		outcome string
+	}{
+		{"no tests", nil, TestSkipped},
+		{"all skipped", []string{TestSkipped, TestSkipped}, TestSkipped},
+		{"passed", []string{"Succeeded"}, TestPassed},
+		{"passed and skipped", []string{"Succeeded", TestSkipped}, TestPassed},
+		{"failed", []string{"Succeeded", "Failed"}, TestFailed},
+		{"failed and skipped", []string{"Failed", TestSkipped}, TestFailed},
+		{"unknown phase", []string{"Unknown"}, TestFailed},
+	}
+
+	for _, c := range cases {
+		results := []models.TestResult{}
+		for _, phase := range c.phases {
+			results = append(results, models.TestResult{Name: "test", Phase: phase})
+		}
+		if outcome := TestOutcome(results); outcome != c.outcome {
+			t.Errorf("%s: got %s, want %s", c.name, outcome, c.outcome)
+		}
+	}
+}
+
+func TestTestSelected(t *testing.T) {
+	cases := []struct {
+		test     string
+		filter   []string
+		selected bool
+	}{
+		{"a", nil, true},
+		{"a", []string{"a"}, true},
+		{"b", []string{"a"}, false},
+		{"a", []string{"!a"}, false},
+		{"b", []string{"!a"}, true},
+		{"a", []string{"a", "!a"}, false},
+	}
+
+	for _, c := range cases {
+		if selected := testSelected(c.test, c.filter); selected != c.selected {
+			t.Errorf("%s with filter %v: got %t, want %t", c.test, c.filter, selected, c.selected)
+		}
+	}
+}
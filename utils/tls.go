@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Identity method of callers authenticated by a client certificate
+const AuthCert = "cert"
+
+// How often the certificate files are checked for changes, on a handshake
+const tlsReloadCheck = 5 * time.Second
+
+// Certificate, key and client authorities which are loaded again when their files change. A reload failing, like
+// when the certificate and the key are not both replaced yet, keeps the previous ones.
+type tlsFiles struct {
+	sync.Mutex
+	certFile string
+	keyFile  string
+	caFile   string
+	cert     *tls.Certificate
+	cas      *x509.CertPool
+	modified time.Time
+	checked  time.Time
+}
+
+// Builds the tls config of the server from the tls settings, with certificates reloaded on change and client
+// certificates requested or required as configured.
+func ServerTLSConfig() (*tls.Config, error) {
+	settings := config.Get().TLS
+
+	files := &tlsFiles{certFile: settings.CertFile, keyFile: settings.KeyFile, caFile: settings.ClientCAFile}
+
+	if err := files.load(); err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion: config.TLSVersions[settings.MinVersion],
+		ClientAuth: tls.NoClientCert,
+	}
+
+	for _, name := range settings.CipherSuites {
+		id, _ := config.CipherSuite(name)
+		base.CipherSuites = append(base.CipherSuites, id)
+	}
+
+	switch settings.ClientAuth {
+	case "request":
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	// Every handshake gets the certificate and the client authorities in use at that moment
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := files.current()
+		return cert, nil
+	}
+
+	if files.caFile != "" {
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			_, cas := files.current()
+
+			conf := base.Clone()
+			conf.GetConfigForClient = nil
+			conf.ClientCAs = cas
+
+			return conf, nil
+		}
+	}
+
+	return base, nil
+}
+
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool) {
+	f.Lock()
+	defer f.Unlock()
+
+	if time.Since(f.checked) > tlsReloadCheck {
+		f.checked = time.Now()
+
+		if modified := f.lastModified(); !modified.Equal(f.modified) {
+			f.Unlock()
+			err := f.load()
+			f.Lock()
+
+			if err != nil {
+				log.Printf("Keeping the previous tls certificate, reloading failed: %-v\n", err)
+			} else {
+				log.Printf("Reloaded tls certificate %s\n", f.certFile)
+			}
+		}
+	}
+
+	return f.cert, f.cas
+}
+
+// Latest modification of the files, the zero time when one cannot be read
+func (f *tlsFiles) lastModified() time.Time {
+	var latest time.Time
+
+	for _, file := range []string{f.certFile, f.keyFile, f.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
+
+func (f *tlsFiles) load() error {
+	modified := f.lastModified()
+
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+
+	if err != nil {
+		return errors.Wrap(err, "invalid tls certificate or key")
+	}
+
+	var cas *x509.CertPool
+
+	if f.caFile != "" {
+		data, err := ioutil.ReadFile(f.caFile)
+
+		if err != nil {
+			return err
+		}
+
+		cas = x509.NewCertPool()
+
+		if !cas.AppendCertsFromPEM(data) {
+			return errors.Errorf("no certificate found in %s", f.caFile)
+		}
+	}
+
+	f.Lock()
+	f.cert, f.cas, f.modified = &cert, cas, modified
+	f.Unlock()
+
+	return nil
+}
+
+// Identity of a verified client certificate: its common name with its organizations as groups, the way kubernetes
+// maps client certificates.
+func certIdentity(cert *x509.Certificate) (models.Identity, error) {
+	if cert.Subject.CommonName == "" {
+		return models.Identity{}, errors.Wrap(ErrUnauthenticated, "client certificate has no common name")
+	}
+
+	groups := append([]string{}, cert.Subject.Organization...)
+
+	return models.Identity{Name: cert.Subject.CommonName, Method: AuthCert, Groups: groups}, nil
+}
@@ -0,0 +1,366 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/Masterminds/semver/v3"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Overwrite policies applied when a chart version is uploaded again with a different content
+const (
+	OverwriteReject     = "reject"
+	OverwriteAllow      = "overwrite"
+	OverwritePrerelease = "prerelease"
+)
+
+var (
+	// ErrChartExists is returned when the overwrite policy refuses to replace a stored chart version
+	ErrChartExists = errors.New("chart version already exists")
+	// ErrInvalidChart is returned when the uploaded archive cannot be loaded as a helm chart
+	ErrInvalidChart = errors.New("invalid chart archive")
+	// ErrDigestMismatch is returned when an archive does not match the digest it was expected to have
+	ErrDigestMismatch = errors.New("chart archive digest mismatch")
+)
+
+// Names charts may be stored under, lower case letters and digits separated by dashes, dots or underscores. Helm
+// does not restrict them, but they become directories of the storage.
+var validChartName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9._]*[a-z0-9])?$`)
+
+// Checks that a chart name and version are safe to store, the version has to be strict semver
+func ValidateChartVersion(name string, version string) error {
+	if !validChartName.MatchString(name) {
+		return errors.Wrapf(ErrInvalidChart, "chart name %q is not valid, use lower case letters, digits, dashes, dots and underscores", name)
+	}
+
+	if _, err := semver.StrictNewVersion(version); err != nil {
+		return errors.Wrapf(ErrInvalidChart, "chart %s version %q is not valid semver", name, version)
+	}
+
+	return nil
+}
+
+// Location of the archive of a chart version under the storage, refused when it would end up outside of it
+func archivePath(name string, version string) (string, error) {
+	if err := ValidateChartVersion(name, version); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(ChartDir, name, version, name+"-"+version+".tgz")
+
+	if !strings.HasPrefix(path, filepath.Clean(ChartDir)+string(filepath.Separator)) {
+		return "", errors.Wrapf(ErrInvalidChart, "chart %s version %s would be stored outside of the storage", name, version)
+	}
+
+	return path, nil
+}
+
+// Options of storing a chart archive, the zero value stores an upload as is
+type StoreOptions struct {
+	// Name stored under instead of the name of the Chart.yaml, used for charts of upstream repositories
+	Name string
+	// Upstream repository the archive was fetched from
+	Upstream string
+	// Expected sha256 digest of the archive, checked when set
+	Digest string
+	// Called with the name the chart is stored under before anything is stored, storing stops on an error
+	Allow func(name string) error
+}
+
+// Outcome of storing a chart archive
+type StoreResult struct {
+	Chart models.Chart
+	// Created is false when an existing version got overwritten or was already identical
+	Created bool
+	// Unchanged is set when the upload matched the digest of the stored archive, nothing was written then
+	Unchanged bool
+	// Lint report of the uploaded archive, also set when the lint strictness refused it
+	Lint models.LintReport
+	// Resolution of the dependencies declared in Chart.yaml, also set when some could not be resolved
+	Dependencies []models.DependencyStatus
+}
+
+// Policy applied on re-uploads, configured by storage.overwritePolicy (HELMER_OVERWRITE_POLICY) and defaulting to reject
+func OverwritePolicy() string {
+	switch policy := config.Get().Storage.OverwritePolicy; policy {
+	case OverwriteAllow, OverwritePrerelease:
+		return policy
+	case "", OverwriteReject:
+		return OverwriteReject
+	default:
+		log.Printf("Unknown overwrite policy %s, falling back to %s\n", policy, OverwriteReject)
+		return OverwriteReject
+	}
+}
+
+// Check if the policy lets an existing chart version be replaced
+func CanOverwrite(policy string, version string) bool {
+	switch policy {
+	case OverwriteAllow:
+		return true
+	case OverwritePrerelease:
+		v, err := semver.NewVersion(version)
+		return err == nil && v.Prerelease() != ""
+	}
+	return false
+}
+
+// Stores a chart archive under the chart storage and records it in the charts table. The archive is written
+// into a temporary file first and only renamed into place once it loaded as a valid chart, name and version
+// are taken from its Chart.yaml. Re-uploads of an identical archive are a no-op, different contents for an
+// existing version are subject to the overwrite policy.
+func StoreChart(db *sql.DB, archive io.Reader) (StoreResult, error) {
+	return StoreChartWith(db, archive, StoreOptions{})
+}
+
+// Stores a chart archive like StoreChart does, with the given options
+func StoreChartWith(db *sql.DB, archive io.Reader, opts StoreOptions) (StoreResult, error) {
+	var result StoreResult
+
+	if err := os.MkdirAll(ChartDir, os.ModePerm); err != nil {
+		return result, err
+	}
+
+	// Not a .tgz name, the watcher leaves temporary files alone
+	tmp, err := ioutil.TempFile(ChartDir, ".upload-*")
+
+	if err != nil {
+		return result, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(tmp, hash), archive)
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	if opts.Digest != "" && !strings.EqualFold(strings.TrimPrefix(opts.Digest, "sha256:"), digest) {
+		return result, errors.Wrapf(ErrDigestMismatch, "expected digest %s, got %s", opts.Digest, digest)
+	}
+
+	charted, err := loader.Load(tmp.Name())
+
+	if err != nil {
+		return result, errors.Wrap(ErrInvalidChart, err.Error())
+	}
+
+	name := charted.Metadata.Name
+
+	if opts.Name != "" {
+		name = opts.Name
+	}
+
+	version := charted.Metadata.Version
+
+	path, err := archivePath(name, version)
+
+	if err != nil {
+		return result, err
+	}
+
+	if opts.Allow != nil {
+		if err := opts.Allow(name); err != nil {
+			return result, err
+		}
+	}
+
+	strictness := LintStrictness()
+
+	report, err := LintChart(tmp.Name(), strictness)
+
+	if err != nil {
+		return result, err
+	}
+
+	report.Name, report.Version = name, version
+
+	result.Lint = report
+
+	if LintRejects(strictness, report) {
+		return result, errors.Wrapf(ErrLintFailed, "chart %s version %s has lint %s messages, lint strictness is %s", name, version, report.Status, strictness)
+	}
+
+	// Digest of the archive actually stored, differs from the uploaded one once dependencies got vendored
+	storedDigest := digest
+
+	if mode := DependencyMode(); mode != DependencyOff && len(charted.Metadata.Dependencies) > 0 {
+		packaged := len(charted.Dependencies())
+
+		result.Dependencies, err = ResolveDependencies(db, charted)
+
+		if err != nil {
+			return result, err
+		}
+
+		if mode == DependencyVendor && len(charted.Dependencies()) > packaged {
+			if storedDigest, err = vendorDependencies(charted, tmp.Name()); err != nil {
+				return result, err
+			}
+			log.Printf("Vendored %d dependencies into chart %s version %s\n", len(charted.Dependencies())-packaged, name, version)
+		}
+	}
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	existing, found, err := chartQuery.GetChartVersion(db, name, version)
+
+	if err != nil {
+		return result, err
+	}
+
+	if found {
+		existingDigest := existing.SourceDigest
+
+		if existingDigest == "" {
+			existingDigest = existing.Digest
+		}
+
+		// Rows recorded before digests were stored get theirs computed from the archive
+		if existingDigest == "" {
+			existingDigest, _ = provenance.DigestFile(existing.Path)
+		}
+
+		if existingDigest == digest && FileExists(existing.Path) {
+			log.Printf("Chart %s version %s is identical to the stored one, nothing to do\n", name, version)
+			existing.Digest = digest
+			return StoreResult{Chart: existing, Unchanged: true, Lint: report}, nil
+		}
+
+		// Charts of an upstream never replace an upload or the charts of another upstream, whatever the policy
+		if opts.Upstream != "" && existing.Upstream != opts.Upstream {
+			return result, errors.Wrapf(ErrChartExists, "chart %s version %s is stored from another source than upstream %s", name, version, opts.Upstream)
+		}
+
+		if policy := OverwritePolicy(); !CanOverwrite(policy, version) {
+			return result, errors.Wrapf(ErrChartExists, "chart %s version %s exists with a different digest, overwrite policy is %s", name, version, policy)
+		}
+
+		log.Printf("Overwriting chart %s version %s\n", name, version)
+	}
+
+	chart := models.Chart{Name: name, Version: version, Path: path, Digest: storedDigest, Lint: report.Status, Provenance: ProvenanceNone, Upstream: opts.Upstream}
+
+	if storedDigest != digest {
+		chart.SourceDigest = digest
+	}
+
+	ApplyMetadata(&chart, charted.Metadata)
+
+	// A new version reserves its row before the archive is moved into place, of concurrent uploads only the one
+	// inserting it gets to store its archive
+	if !found {
+		id, added, err := chartQuery.AddChart(db, chart, report.Messages, false)
+
+		if err != nil {
+			return result, err
+		}
+
+		if !added {
+			return result, errors.Wrapf(ErrChartExists, "chart %s version %s was stored by another upload meanwhile", name, version)
+		}
+
+		chart.ID = id
+	}
+
+	if err := storeArchive(tmp.Name(), path); err != nil {
+		if !found {
+			chartQuery.RemoveChartByID(db, chart.ID)
+		}
+		return result, err
+	}
+
+	// A provenance file of the previous content no longer matches the archive
+	os.Remove(ProvenancePath(path))
+
+	if found {
+		chart.ID, _, err = chartQuery.AddChart(db, chart, report.Messages, true)
+
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return StoreResult{Chart: chart, Created: !found, Lint: report, Dependencies: result.Dependencies}, nil
+}
+
+// Moves an archive written to the storage into place
+func storeArchive(tmp string, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, 0644)
+}
+
+// Packages a chart with its resolved dependencies over the uploaded archive and returns the new digest. The
+// archive is packaged outside of the chart storage and copied back so the watcher never sees it.
+func vendorDependencies(charted *chart.Chart, archive string) (string, error) {
+	dir, err := ioutil.TempDir("", "helmer-vendor-")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(dir)
+
+	packaged, err := chartutil.Save(charted, dir)
+
+	if err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(packaged)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer src.Close()
+
+	dst, err := os.Create(archive)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(dst, src)
+
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return provenance.DigestFile(archive)
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"github.com/pkg/errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateChartVersion(t *testing.T) {
+	cases := []struct {
+		name    string
+		version string
+		valid   bool
+	}{
+		{"mysql", "1.2.3", true},
+		{"my-chart.v2_x", "0.1.0-rc.1+build.5", true},
+		{"a", "1.0.0", true},
+		{"", "1.0.0", false},
+		{"../../etc/x", "1.0.0", false},
+		{"a/b", "1.0.0", false},
+		{"..", "1.0.0", false},
+		{".hidden", "1.0.0", false},
+		{"trailing-", "1.0.0", false},
+		{"Upper", "1.0.0", false},
+		{"mysql", "1.0", false},
+		{"mysql", "v1.0.0", false},
+		{"mysql", "1.0.0/../../x", false},
+		{"mysql", "", false},
+	}
+
+	for _, c := range cases {
+		err := ValidateChartVersion(c.name, c.version)
+		if (err == nil) != c.valid {
+			t.Errorf("%q %q: got error %v, want valid %t", c.name, c.version, err, c.valid)
+		}
+		if err != nil && errors.Cause(err) != ErrInvalidChart {
+			t.Errorf("%q %q: got error %v, want ErrInvalidChart", c.name, c.version, err)
+		}
+	}
+}
+
+func TestArchivePath(t *testing.T) {
+	defer func(dir string) { ChartDir = dir }(ChartDir)
+
+	ChartDir = "/srv/charts/"
+
+	path, err := archivePath("mysql", "1.2.3")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.FromSlash("/srv/charts/mysql/1.2.3/mysql-1.2.3.tgz"); path != want {
+		t.Errorf("got %s, want %s", path, want)
+	}
+
+	if _, err := archivePath("..", "1.2.3"); err == nil {
+		t.Error("a name escaping the storage was accepted")
+	}
+}
+
+func TestCanOverwrite(t *testing.T) {
+	cases := []struct {
+		policy  string
+		version string
+		allowed bool
+	}{
+		{OverwriteReject, "1.0.0", false},
+		{OverwriteReject, "1.0.0-rc.1", false},
+		{OverwriteAllow, "1.0.0", true},
+		{OverwritePrerelease, "1.0.0", false},
+		{OverwritePrerelease, "1.0.0-rc.1", true},
+	}
+
+	for _, c := range cases {
+		if allowed := CanOverwrite(c.policy, c.version); allowed != c.allowed {
+			t.Errorf("%s %s: got %t, want %t", c.policy, c.version, allowed, c.allowed)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Upstream helm repository proxied by helmer, its charts are listed in the index under Prefix and fetched on demand
+type Upstream struct {
+	Name string `json:"name"`
+	// Repository url, index.yaml is fetched from below it
+	URL string `json:"url"`
+	// Prefix given to the chart names of the repository, defaults to "<name>-"
+	Prefix                string `json:"prefix"`
+	Username              string `json:"username"`
+	Password              string `json:"password"`
+	CAFile                string `json:"caFile"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify"`
+	// Sends the credentials along to archive urls of the index on other hosts than the repository, like helm's
+	// --pass-credentials
+	PassCredentials bool `json:"passCredentials"`
+
+	client *http.Client
+	mu     sync.Mutex
+	index  *repo.IndexFile
+	// Error of the last index fetch, and the channel closed once the running fetch ends
+	fetchErr error
+	fetching chan struct{}
+	fetched  time.Time
+}
+
+// Repositories file read from HELMER_REPOSITORIES
+type RepositoryConfig struct {
+	Upstreams []*Upstream `json:"upstreams"`
+	Mirrors   []*Mirror   `json:"mirrors"`
+}
+
+// ErrUnknownUpstream is returned when a request names an upstream repository which is not configured
+var ErrUnknownUpstream = errors.New("unknown upstream repository")
+
+// How long a fetched upstream index is used before it is fetched again
+var UpstreamIndexTTL = 5 * time.Minute
+
+var upstreamName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+var upstreams []*Upstream
+
+// Location of the repositories file, configured by storage.repositories (HELMER_REPOSITORIES)
+func RepositoriesFile() string {
+	return config.Get().Storage.Repositories
+}
+
+// Loads the upstream repositories and the mirrors from the repositories file, having no file configured means
+// there are none.
+func LoadRepositories() error {
+	config, err := LoadRepositoryConfig()
+
+	if err != nil {
+		return err
+	}
+
+	if err := loadUpstreams(config); err != nil {
+		return err
+	}
+
+	return loadMirrors(config)
+}
+
+func loadUpstreams(config RepositoryConfig) error {
+	seen := map[string]bool{}
+
+	for _, u := range config.Upstreams {
+		if !upstreamName.MatchString(u.Name) {
+			return errors.Errorf("upstream name %q is not a lowercase dns label", u.Name)
+		}
+		if seen[u.Name] {
+			return errors.Errorf("upstream %s is configured twice", u.Name)
+		}
+		seen[u.Name] = true
+
+		if err := u.connect(); err != nil {
+			return errors.Wrapf(err, "upstream %s", u.Name)
+		}
+
+		if u.Prefix == "" {
+			u.Prefix = u.Name + "-"
+		}
+
+		log.Printf("Proxying upstream repository %s from %s under prefix %s\n", u.Name, u.URL, u.Prefix)
+	}
+
+	upstreams = config.Upstreams
+
+	return nil
+}
+
+// Reads the repositories file, an empty config is returned when none is configured
+func LoadRepositoryConfig() (RepositoryConfig, error) {
+	var config RepositoryConfig
+
+	if RepositoriesFile() == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(RepositoriesFile())
+
+	if err != nil {
+		return config, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, errors.Wrapf(err, "invalid repositories file %s", RepositoriesFile())
+	}
+
+	return config, nil
+}
+
+// Configured upstream repositories
+func Upstreams() []*Upstream {
+	return upstreams
+}
+
+// Looks up a configured upstream repository by name
+func GetUpstream(name string) (*Upstream, error) {
+	for _, u := range upstreams {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrUnknownUpstream, "upstream %s is not configured", name)
+}
+
+// Finds the upstream repository a chart name belongs to by its prefix, along with the name of the chart upstream
+func UpstreamFor(name string) (*Upstream, string) {
+	var found *Upstream
+
+	for _, u := range upstreams {
+		if strings.HasPrefix(name, u.Prefix) && len(name) > len(u.Prefix) && (found == nil || len(u.Prefix) > len(found.Prefix)) {
+			found = u
+		}
+	}
+
+	if found == nil {
+		return nil, ""
+	}
+
+	return found, strings.TrimPrefix(name, found.Prefix)
+}
+
+// Checks the url of the repository and sets up the client used to reach it
+func (u *Upstream) connect() error {
+	parsed, err := url.Parse(u.URL)
+
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return errors.Errorf("%q is not a valid http(s) url", u.URL)
+	}
+
+	u.URL = strings.TrimSuffix(u.URL, "/")
+
+	u.client, err = u.httpClient()
+
+	return err
+}
+
+func (u *Upstream) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: u.InsecureSkipTLSVerify}
+
+	if u.CAFile != "" {
+		pem, err := ioutil.ReadFile(u.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s", u.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport, Timeout: 5 * time.Minute}, nil
+}
+
+// Performs a GET against the repository, anything but a 200 is an error. The credentials are only sent to the
+// scheme and host of the repository url unless passCredentials is set, the index may point anywhere.
+func (u *Upstream) get(target string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Username != "" || u.Password != "") && (u.PassCredentials || u.sameOrigin(req.URL)) {
+		req.SetBasicAuth(u.Username, u.Password)
+	}
+
+	resp, err := u.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("fetching %s failed with status %s", target, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// Check if a url has the scheme and host of the repository url
+func (u *Upstream) sameOrigin(target *url.URL) bool {
+	repository, err := url.Parse(u.URL)
+	return err == nil && strings.EqualFold(target.Scheme, repository.Scheme) && strings.EqualFold(target.Host, repository.Host)
+}
+
+// Index of the upstream repository, fetched again once older than UpstreamIndexTTL. The previous index keeps
+// being used when the repository cannot be reached. Only one fetch runs at a time and without holding the lock,
+// callers meanwhile get the previous index or, when there is none yet, wait for the fetch.
+func (u *Upstream) Index() (*repo.IndexFile, error) {
+	u.mu.Lock()
+
+	if u.index != nil && (time.Since(u.fetched) < UpstreamIndexTTL || u.fetching != nil) {
+		index := u.index
+		u.mu.Unlock()
+		return index, nil
+	}
+
+	if u.fetching != nil {
+		fetching := u.fetching
+		u.mu.Unlock()
+
+		<-fetching
+
+		u.mu.Lock()
+		defer u.mu.Unlock()
+
+		if u.index == nil {
+			return nil, u.fetchErr
+		}
+		return u.index, nil
+	}
+
+	fetching := make(chan struct{})
+	u.fetching = fetching
+
+	u.mu.Unlock()
+
+	index, err := u.fetchIndex()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.fetching = nil
+	u.fetchErr = err
+	close(fetching)
+
+	if err != nil {
+		if u.index != nil {
+			log.Printf("Using the stale index of upstream %s: %-v\n", u.Name, err)
+			return u.index, nil
+		}
+		return nil, err
+	}
+
+	u.index, u.fetched = index, time.Now()
+
+	return index, nil
+}
+
+func (u *Upstream) fetchIndex() (*repo.IndexFile, error) {
+	resp, err := u.get(u.URL + "/index.yaml")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	index := repo.NewIndexFile()
+
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, errors.Wrapf(err, "invalid index of upstream %s", u.Name)
+	}
+
+	index.SortEntries()
+
+	return index, nil
+}
+
+// Downloads the archive of a chart version listed in the index of the repository, the caller closes the response
+func (u *Upstream) Download(cv *repo.ChartVersion) (*http.Response, error) {
+	if len(cv.URLs) == 0 {
+		return nil, errors.Errorf("chart %s version %s of upstream %s has no download url", cv.Name, cv.Version, u.Name)
+	}
+
+	archiveURL, err := repo.ResolveReferenceURL(u.URL, cv.URLs[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return u.get(archiveURL)
+}
+
+// Url the archive of an upstream chart version is served under by helmer, relative to the repository root
+func upstreamArchiveURL(u *Upstream, name string, version string) string {
+	return path.Join("upstreams", u.Name, name, version, u.Prefix+name+"-"+version+".tgz")
+}
+
+// Index served by helmer, the index of the stored charts merged with the indexes of the upstream repositories.
+// Upstream charts are listed under the prefix of their repository and point at helmer, which fetches their
+// archives on first request. An unreachable upstream is left out of the index.
+func MergedIndex() (*repo.IndexFile, error) {
+	index, err := repo.LoadIndexFile(IndexPath())
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range upstreams {
+		upstreamIndex, err := u.Index()
+
+		if err != nil {
+			log.Printf("Leaving upstream %s out of the index: %-v\n", u.Name, err)
+			continue
+		}
+
+		for name, versions := range upstreamIndex.Entries {
+			prefixed := u.Prefix + name
+
+			for _, cv := range versions {
+				// Cached versions are already listed from the local storage
+				if cv.Metadata == nil || index.Has(prefixed, cv.Version) {
+					continue
+				}
+
+				entry := *cv
+				md := *cv.Metadata
+				md.Name = prefixed
+				entry.Metadata = &md
+				entry.URLs = []string{upstreamArchiveURL(u, name, cv.Version)}
+
+				index.Entries[prefixed] = append(index.Entries[prefixed], &entry)
+			}
+		}
+	}
+
+	index.SortEntries()
+
+	return index, nil
+}
+
+// Resolves a version request against the index of an upstream repository and caches the picked version, see
+// ResolveChartVersion for the accepted requests.
+func ResolveUpstreamChart(db *sql.DB, u *Upstream, name string, requested string, prerelease bool) (models.Chart, error) {
+	index, err := u.Index()
+
+	if err != nil {
+		return models.Chart{}, err
+	}
+
+	versions := []string{}
+
+	for _, cv := range index.Entries[name] {
+		versions = append(versions, cv.Version)
+	}
+
+	version, ok := PickVersion(versions, requested, prerelease)
+
+	if !ok {
+		return models.Chart{}, errors.Wrapf(ErrChartNotFound, "chart %s of upstream %s has no version matching %s", name, u.Name, requested)
+	}
+
+	return CacheUpstreamChart(db, u, name, version)
+}
+
+// Returns the cached copy of an upstream chart version, fetching it into the chart storage on first request.
+// Cached charts are stored under the prefixed name and verified against the digest listed upstream.
+func CacheUpstreamChart(db *sql.DB, u *Upstream, name string, version string) (models.Chart, error) {
+	chartQuery := chartQueries.ChartQueries{}
+
+	cached, found, err := chartQuery.GetChartVersion(db, u.Prefix+name, version)
+
+	if err != nil {
+		return cached, err
+	}
+
+	if found && FileExists(cached.Path) {
+		return cached, nil
+	}
+
+	index, err := u.Index()
+
+	if err != nil {
+		return models.Chart{}, err
+	}
+
+	cv, err := index.Get(name, version)
+
+	if err != nil || cv.Version != version {
+		return models.Chart{}, errors.Wrapf(ErrChartNotFound, "chart %s of upstream %s has no version %s", name, u.Name, version)
+	}
+
+	resp, err := u.Download(cv)
+
+	if err != nil {
+		return models.Chart{}, err
+	}
+
+	defer resp.Body.Close()
+
+	result, err := StoreChartWith(db, resp.Body, StoreOptions{Name: u.Prefix + name, Upstream: u.Name, Digest: cv.Digest})
+
+	if err != nil {
+		return result.Chart, err
+	}
+
+	log.Printf("Cached chart %s version %s of upstream %s\n", name, version, u.Name)
+
+	if err := GenerateIndex(db); err != nil {
+		log.Printf("Failed to regenerate the repository index: %-v\n", err)
+	}
+
+	return result.Chart, nil
+}
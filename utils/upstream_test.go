@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Packages a minimal chart and returns its archive
+func testChartArchive(t *testing.T, name string, version string) []byte {
+	dir, err := ioutil.TempDir("", "helmer-chart-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	charted := &chart.Chart{
+		Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version, Type: "application"},
+		Templates: []*chart.File{{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\n")}},
+	}
+
+	packaged, err := chartutil.Save(charted, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(packaged)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func testDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Fixture repository serving an index and the archives listed in it, recording the credentials of every request
+type testRepository struct {
+	*httptest.Server
+	mu       sync.Mutex
+	index    *repo.IndexFile
+	archives map[string][]byte
+	auth     map[string]string
+	delay    time.Duration
+}
+
+func newTestRepository() *testRepository {
+	r := &testRepository{index: repo.NewIndexFile(), archives: map[string][]byte{}, auth: map[string]string{}}
+
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		user, password, _ := req.BasicAuth()
+		r.auth[req.URL.Path] = user + ":" + password
+		delay := r.delay
+		r.mu.Unlock()
+
+		time.Sleep(delay)
+
+		if req.URL.Path == "/index.yaml" {
+			r.mu.Lock()
+			data, _ := yaml.Marshal(r.index)
+			r.mu.Unlock()
+			w.Write(data)
+			return
+		}
+
+		r.mu.Lock()
+		data, ok := r.archives[req.URL.Path]
+		r.mu.Unlock()
+
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		w.Write(data)
+	}))
+
+	return r
+}
+
+// Adds an archive served under path, listed in the index with the given url
+func (r *testRepository) add(t *testing.T, name string, version string, path string, url string) []byte {
+	data := testChartArchive(t, name, version)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.archives[path] = data
+	r.index.Add(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version}, url, "", "sha256:"+testDigest(data))
+
+	return data
+}
+
+func (r *testRepository) credentials(path string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.auth[path]
+}
+
+func testUpstream(t *testing.T, url string) *Upstream {
+	u := &Upstream{Name: "fix", URL: url, Prefix: "fix-", Username: "user", Password: "secret"}
+
+	if err := u.connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestMergedIndex(t *testing.T) {
+	defer func(dir string, list []*Upstream) { ChartDir, upstreams = dir, list }(ChartDir, upstreams)
+
+	dir, err := ioutil.TempDir("", "helmer-storage-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ChartDir = dir
+
+	local := repo.NewIndexFile()
+	local.Add(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "local", Version: "1.0.0"}, "local/1.0.0/local-1.0.0.tgz", "", "sha256:00")
+	// Cached upstream versions are listed from the storage
+	local.Add(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: "fix-nginx", Version: "1.0.0"}, "fix-nginx/1.0.0/fix-nginx-1.0.0.tgz", "", "sha256:00")
+
+	if err := local.WriteFile(IndexPath(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := newTestRepository()
+	defer fixture.Close()
+
+	fixture.add(t, "nginx", "1.0.0", "/charts/nginx-1.0.0.tgz", "charts/nginx-1.0.0.tgz")
+	fixture.add(t, "nginx", "2.0.0", "/charts/nginx-2.0.0.tgz", "charts/nginx-2.0.0.tgz")
+
+	upstreams = []*Upstream{testUpstream(t, fixture.URL)}
+
+	index, err := MergedIndex()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !index.Has("local", "1.0.0") {
+		t.Error("the stored chart is missing from the merged index")
+	}
+
+	versions := index.Entries["fix-nginx"]
+
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions of fix-nginx, want 2", len(versions))
+	}
+
+	if versions[0].Version != "2.0.0" || versions[0].URLs[0] != "upstreams/fix/nginx/2.0.0/fix-nginx-2.0.0.tgz" {
+		t.Errorf("got version %s with urls %v", versions[0].Version, versions[0].URLs)
+	}
+
+	if versions[1].URLs[0] != "fix-nginx/1.0.0/fix-nginx-1.0.0.tgz" {
+		t.Errorf("the cached version got url %v, want the stored one", versions[1].URLs)
+	}
+}
+
+func TestUpstreamDownload(t *testing.T) {
+	fixture := newTestRepository()
+	defer fixture.Close()
+
+	archive := fixture.add(t, "nginx", "1.0.0", "/charts/nginx-1.0.0.tgz", "charts/nginx-1.0.0.tgz")
+
+	u := testUpstream(t, fixture.URL)
+
+	index, err := u.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cv, err := index.Get("nginx", "1.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := u.Download(cv)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+
+	if !bytes.Equal(data, archive) {
+		t.Error("the downloaded archive differs from the served one")
+	}
+
+	if _, err := u.Download(&repo.ChartVersion{Metadata: cv.Metadata, URLs: []string{"charts/missing.tgz"}}); err == nil {
+		t.Error("a missing archive downloaded without an error")
+	}
+}
+
+func TestUpstreamDigestMismatch(t *testing.T) {
+	defer func(dir string) { ChartDir = dir }(ChartDir)
+
+	dir, err := ioutil.TempDir("", "helmer-storage-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ChartDir = dir
+
+	archive := testChartArchive(t, "nginx", "1.0.0")
+
+	// The digest is checked before the archive is loaded or anything is recorded
+	_, err = StoreChartWith(nil, bytes.NewReader(archive), StoreOptions{Name: "fix-nginx", Upstream: "fix", Digest: "sha256:" + testDigest([]byte("other"))})
+
+	if errors.Cause(err) != ErrDigestMismatch {
+		t.Errorf("got error %v, want ErrDigestMismatch", err)
+	}
+}
+
+func TestUpstreamCredentials(t *testing.T) {
+	fixture := newTestRepository()
+	defer fixture.Close()
+
+	other := newTestRepository()
+	defer other.Close()
+
+	other.add(t, "nginx", "1.0.0", "/nginx-1.0.0.tgz", "")
+	fixture.add(t, "nginx", "1.0.0", "/charts/nginx-1.0.0.tgz", "charts/nginx-1.0.0.tgz")
+
+	cases := []struct {
+		url             string
+		passCredentials bool
+		server          *testRepository
+		path            string
+		credentials     string
+	}{
+		{"charts/nginx-1.0.0.tgz", false, fixture, "/charts/nginx-1.0.0.tgz", "user:secret"},
+		{fixture.URL + "/charts/nginx-1.0.0.tgz", false, fixture, "/charts/nginx-1.0.0.tgz", "user:secret"},
+		{other.URL + "/nginx-1.0.0.tgz", false, other, "/nginx-1.0.0.tgz", ":"},
+		{other.URL + "/nginx-1.0.0.tgz", true, other, "/nginx-1.0.0.tgz", "user:secret"},
+	}
+
+	for _, c := range cases {
+		u := testUpstream(t, fixture.URL)
+		u.PassCredentials = c.passCredentials
+
+		resp, err := u.Download(&repo.ChartVersion{Metadata: &chart.Metadata{Name: "nginx", Version: "1.0.0"}, URLs: []string{c.url}})
+
+		if err != nil {
+			t.Errorf("%s: %v", c.url, err)
+			continue
+		}
+
+		resp.Body.Close()
+
+		if got := c.server.credentials(c.path); got != c.credentials {
+			t.Errorf("%s with passCredentials %t: server got credentials %q, want %q", c.url, c.passCredentials, got, c.credentials)
+		}
+	}
+
+	if got := fixture.credentials("/index.yaml"); got != "" {
+		t.Errorf("got credentials %q before the index was fetched", got)
+	}
+}
+
+func TestUpstreamIndexStale(t *testing.T) {
+	defer func(ttl time.Duration) { UpstreamIndexTTL = ttl }(UpstreamIndexTTL)
+
+	fixture := newTestRepository()
+	defer fixture.Close()
+
+	fixture.add(t, "nginx", "1.0.0", "/charts/nginx-1.0.0.tgz", "charts/nginx-1.0.0.tgz")
+
+	u := testUpstream(t, fixture.URL)
+
+	if _, err := u.Index(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow refetch keeps answering with the previous index instead of waiting for it
+	UpstreamIndexTTL = 0
+
+	fixture.mu.Lock()
+	fixture.delay = 500 * time.Millisecond
+	fixture.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		u.Index()
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	started := time.Now()
+
+	index, err := u.Index()
+
+	if err != nil || !index.Has("nginx", "1.0.0") {
+		t.Fatalf("got index %v and error %v", index, err)
+	}
+
+	if waited := time.Since(started); waited > 250*time.Millisecond {
+		t.Errorf("waited %s for the running fetch", waited)
+	}
+
+	<-done
+}
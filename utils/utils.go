@@ -2,6 +2,7 @@ package utils
 
 import (
 	"database/sql"
+	"github.com/mainak90/helmer/config"
 	chartQueries "github.com/mainak90/helmer/queries/chart"
 	"github.com/pkg/errors"
 	"github.com/radovskyb/watcher"
@@ -13,7 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 
 	"helm.sh/helm/v3/pkg/action"
 )
@@ -33,23 +34,30 @@ func FileExists(filename string) bool {
 func GetActionConfig(namespace string) (*action.Configuration, error) {
 	var configFile string
 	actionConfig := new(action.Configuration)
+	// A kubeconfig configured by cluster.kubeconfig takes precedence over the detected ones
+	if kubeconfig := config.Get().Cluster.Kubeconfig; kubeconfig != "" {
+		if err := actionConfig.Init(kube.GetConfig(kubeconfig, "", namespace), namespace, config.Get().Cluster.HelmDriver, log.Printf); err != nil {
+			return nil, err
+		}
+		return actionConfig, nil
+	}
 	// Incase this is run in-cluster, uses the serviceaccount to create resources, must have cluster
 	// level rolebinding
 	if FileExists("/var/run/secrets/kubernetes.io/serviceaccount/token") {
 		log.Printf("In-cluster config detected!")
 		var kubeConfig *genericclioptions.ConfigFlags
 		// Create the rest config instance with ServiceAccount values loaded in them
-		config, err := rest.InClusterConfig()
+		restConfig, err := rest.InClusterConfig()
 		if err != nil {
 			return nil, err
 		}
 		// Create the ConfigFlags struct instance with initialized values from ServiceAccount
 		kubeConfig = genericclioptions.NewConfigFlags(false)
-		kubeConfig.APIServer = &config.Host
-		kubeConfig.BearerToken = &config.BearerToken
-		kubeConfig.CAFile = &config.CAFile
+		kubeConfig.APIServer = &restConfig.Host
+		kubeConfig.BearerToken = &restConfig.BearerToken
+		kubeConfig.CAFile = &restConfig.CAFile
 		kubeConfig.Namespace = &namespace
-		if err := actionConfig.Init(kubeConfig, namespace, os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
+		if err := actionConfig.Init(kubeConfig, namespace, config.Get().Cluster.HelmDriver, log.Printf); err != nil {
 			return nil, err
 		}
 		return actionConfig, nil
@@ -57,7 +65,7 @@ func GetActionConfig(namespace string) (*action.Configuration, error) {
 		// Incase using rancher k3s distro
 		log.Printf("Rancher k3s config detected!")
 		configFile = "/etc/rancher/k3s/k3s.yaml"
-		if err := actionConfig.Init(kube.GetConfig(configFile, "", namespace), namespace, os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
+		if err := actionConfig.Init(kube.GetConfig(configFile, "", namespace), namespace, config.Get().Cluster.HelmDriver, log.Printf); err != nil {
 			return nil, err
 		}
 		return actionConfig, nil
@@ -65,12 +73,11 @@ func GetActionConfig(namespace string) (*action.Configuration, error) {
 		// Create client from standard kubeconfig.
 		log.Printf("Standard kubernetes config detected!")
 		configFile = filepath.Join(os.Getenv("HOME"), ".kube", "config")
-		if err := actionConfig.Init(kube.GetConfig(configFile, "", namespace), namespace, os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
+		if err := actionConfig.Init(kube.GetConfig(configFile, "", namespace), namespace, config.Get().Cluster.HelmDriver, log.Printf); err != nil {
 			return nil, err
 		}
 		return actionConfig, nil
 	}
-	return nil, nil
 }
 
 // Check if a helm release is installed already in the given namespace, incase method returns false,
@@ -109,11 +116,22 @@ func IsChartInstallable(ch *chart.Chart) (bool, error) {
 	return false, errors.Errorf("%s charts are not installable", ch.Metadata.Type)
 }
 
+// Watcher of the chart directory, closed on shutdown
+var storageWatcher struct {
+	sync.Mutex
+	w       *watcher.Watcher
+	running bool
+}
+
 // Standard watcher for deletion action of the chart directory, to avoid manual deletion of charts from
 // fs while the database will continue to persist the tgz chartfile record unless deleted.
 func WatchFile(db *sql.DB) {
 	w := watcher.New()
 
+	storageWatcher.Lock()
+	storageWatcher.w = w
+	storageWatcher.Unlock()
+
 	w.SetMaxEvents(1)
 
 	w.FilterOps(watcher.Remove)
@@ -123,6 +141,10 @@ func WatchFile(db *sql.DB) {
 			select {
 			case event := <-w.Event:
 				log.Println(event)
+				// Only chart archives map to database rows, the index and staged removals are skipped
+				if filepath.Ext(event.Path) != ".tgz" {
+					continue
+				}
 				slices := strings.Split(event.Path, "/")
 				// Resorted to use table row deletion on name and version as using path as field
 				// doesn't work somehow.
@@ -132,17 +154,23 @@ func WatchFile(db *sql.DB) {
 				chartQuery := chartQueries.ChartQueries{}
 				del := chartQuery.RemoveChart(db, name, version)
 				log.Printf("Removed record from database for chart in path %s", name)
-				log.Printf("Rows deleted: %s", del)
+				log.Printf("Rows deleted: %d", del)
+				if err := GenerateIndex(db); err != nil {
+					log.Printf("Failed to regenerate the repository index: %-v\n", err)
+				}
 			case err := <-w.Error:
 				log.Fatalln(err)
 			case <-w.Closed:
+				storageWatcher.Lock()
+				storageWatcher.running = false
+				storageWatcher.Unlock()
 				return
 			}
 		}
 	}()
 
-	// Recursively checking if /tmp/charts has deletion somewhere, the path is hardcoded for now.
-	if err := w.AddRecursive("/tmp/charts/"); err != nil {
+	// Recursively checking if the chart storage has deletion somewhere
+	if err := w.AddRecursive(ChartDir); err != nil {
 		log.Fatalln(err)
 	}
 
@@ -152,14 +180,35 @@ func WatchFile(db *sql.DB) {
 
 	go func() {
 		w.Wait()
+		storageWatcher.Lock()
+		storageWatcher.running = true
+		storageWatcher.Unlock()
 	}()
 
-	// Start the watching process - it'll check for changes every 100ms.
-	if err := w.Start(time.Millisecond * 100); err != nil {
+	// Start the watching process - it'll check for changes every storage.watchInterval, 100ms by default.
+	if err := w.Start(config.Get().Storage.WatchInterval.Duration); err != nil {
 		log.Fatalln(err)
 	}
 }
 
+// Stops the watcher of the chart directory, WatchFile returns once it is closed
+func StopWatching() {
+	storageWatcher.Lock()
+	defer storageWatcher.Unlock()
+
+	if storageWatcher.w != nil {
+		storageWatcher.w.Close()
+	}
+}
+
+// Check if the watcher of the chart directory is running
+func WatcherRunning() bool {
+	storageWatcher.Lock()
+	defer storageWatcher.Unlock()
+
+	return storageWatcher.running
+}
+
 // No idea why this part is not working for now, focussing on the other topics
 //func CreateNS(namespace string) error {
 //	clientset := Client()
@@ -184,7 +233,7 @@ func WatchFile(db *sql.DB) {
 //	var kubeconfig *string
 //	if FileExists("/var/run/secrets/kubernetes.io/serviceaccount/token") {
 //		log.Printf("Incluster config pickedup")
-//		config, err := rest.InClusterConfig()
+//		restConfig, err := rest.InClusterConfig()
 //		if err != nil {
 //			log.Printf("Error encountered: %s", err)
 //			panic(err.Error())
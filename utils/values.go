@@ -0,0 +1,460 @@
+package utils
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mainak90/helmer/config"
+	"github.com/mainak90/helmer/models"
+	chartQueries "github.com/mainak90/helmer/queries/chart"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/strvals"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Prefix of override values sealed with an encryption key, followed by the key id, the wrapped data key and the
+// encrypted values
+const sealedPrefix = "enc:v1:"
+
+var (
+	// ErrSecretValue is returned when a value referenced from a kubernetes secret cannot be read
+	ErrSecretValue = errors.New("unable to read secret value")
+	// ErrValuesRedacted is returned when stored override values were redacted and cannot be deployed again
+	ErrValuesRedacted = errors.New("stored override values are redacted")
+)
+
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Indices of --set paths like hosts[0].password
+var listIndex = regexp.MustCompile(`\[[0-9]+\]`)
+
+// Only warn once about override values stored without encryption
+var warnUnencrypted sync.Once
+
+// Key encryption key the data keys of the override values are wrapped with
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// Keys configured by auth.encryptionKeys (HELMER_ENCRYPTION_KEYS) as id:base64 pairs of 32 byte keys. The first key
+// encrypts, the others are only used to decrypt values sealed before a rotation.
+func EncryptionKeys() ([]EncryptionKey, error) {
+	keys := []EncryptionKey{}
+
+	for _, pair := range config.Get().Auth.EncryptionKeys {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || !validKeyID.MatchString(parts[0]) {
+			return nil, errors.New("HELMER_ENCRYPTION_KEYS must hold id:base64 pairs, ids made of letters, digits, - and _")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, errors.Errorf("encryption key %s is not a base64 encoded 32 byte key", parts[0])
+		}
+		keys = append(keys, EncryptionKey{ID: parts[0], Key: key})
+	}
+
+	return keys, nil
+}
+
+// Seals the override values of a deploy for storage. They are encrypted with a random data key, which is wrapped
+// with the first encryption key. Without encryption keys the values are stored with the secrets redacted.
+func SealValues(vars []string, secrets map[string]bool) (string, error) {
+	keys, err := EncryptionKeys()
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(keys) == 0 {
+		warnUnencrypted.Do(func() {
+			log.Println("No HELMER_ENCRYPTION_KEYS configured, secret override values are stored redacted")
+		})
+		return strings.Join(RedactSetValuesWith(vars, secrets), ","), nil
+	}
+
+	values := strings.Join(vars, ",")
+
+	if values == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, 32)
+
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(keys[0].Key, dataKey)
+
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := seal(dataKey, []byte(values))
+
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + keys[0].ID + ":" + wrapped + ":" + encrypted, nil
+}
+
+// Opens override values sealed by SealValues with any of the encryption keys, values which were never sealed are
+// returned as they are.
+func OpenValues(sealed string) (string, error) {
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return sealed, nil
+	}
+
+	id, dataKey, encrypted, err := unwrap(sealed)
+
+	if err != nil {
+		return "", err
+	}
+
+	values, err := open(dataKey, encrypted)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "values sealed with key %s cannot be decrypted", id)
+	}
+
+	return string(values), nil
+}
+
+// Override values of a deploy as stored, opened when they were sealed. Values stored redacted, before encryption keys
+// were configured, are refused with ErrValuesRedacted as deploying them would set the secrets to the placeholder,
+// unless they are set again by the given vars.
+func StoredValues(stored string, vars []string) ([]string, error) {
+	if stored == "" {
+		return []string{}, nil
+	}
+
+	values, err := OpenValues(stored)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Later values of a key replace the earlier ones, like they do when they are deployed
+	redacted := map[string]bool{}
+
+	for _, value := range append([]string{values}, vars...) {
+		for _, pair := range splitUnescaped(value, ',') {
+			kv := strings.SplitN(pair, "=", 2)
+			redacted[kv[0]] = len(kv) == 2 && kv[1] == Redacted
+		}
+	}
+
+	// Values stored redacted stay so once they are sealed on the next start
+	for key, isRedacted := range redacted {
+		if isRedacted {
+			return nil, errors.Wrapf(ErrValuesRedacted, "value %s was stored redacted", key)
+		}
+	}
+
+	return []string{values}, nil
+}
+
+// Brings the stored override values in line with the encryption keys: data keys wrapped by an older key are wrapped
+// again with the first one, values stored before encryption was configured are sealed. Run on every start.
+func RotateDeployValues(db *sql.DB) {
+	keys, err := EncryptionKeys()
+
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	chartQuery := chartQueries.ChartQueries{}
+
+	stored, err := chartQuery.GetDeployValues(db)
+
+	if err != nil {
+		log.Printf("Unable to read the stored override values: %-v\n", err)
+		return
+	}
+
+	rotated := 0
+
+	for id, values := range stored {
+		resealed, changed, err := resealValues(values, keys[0])
+
+		if err != nil {
+			log.Printf("Skipping override values of deploy %d: %-v\n", id, err)
+			continue
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := chartQuery.UpdateDeployValues(db, id, resealed); err != nil {
+			log.Printf("Unable to store the override values of deploy %d: %-v\n", id, err)
+			continue
+		}
+
+		rotated++
+	}
+
+	if rotated > 0 {
+		log.Printf("Sealed the override values of %d deploys with encryption key %s\n", rotated, keys[0].ID)
+	}
+}
+
+// Stored override values sealed with the given key, the data key of values sealed with an older key is wrapped
+// again and plain values are sealed. Values already sealed with the key are returned unchanged.
+func resealValues(values string, key EncryptionKey) (string, bool, error) {
+	if !strings.HasPrefix(values, sealedPrefix) {
+		sealed, err := SealValues([]string{values}, nil)
+		return sealed, err == nil, err
+	}
+
+	sealedWith, dataKey, encrypted, err := unwrap(values)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if sealedWith == key.ID {
+		return values, false, nil
+	}
+
+	wrapped, err := seal(key.Key, dataKey)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return sealedPrefix + key.ID + ":" + wrapped + ":" + encrypted, true, nil
+}
+
+// Splits sealed values and unwraps their data key with the encryption key they name
+func unwrap(sealed string) (string, []byte, string, error) {
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+
+	if len(parts) != 3 {
+		return "", nil, "", errors.New("malformed sealed values")
+	}
+
+	keys, err := EncryptionKeys()
+
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	for _, key := range keys {
+		if key.ID != parts[0] {
+			continue
+		}
+		dataKey, err := open(key.Key, parts[1])
+		if err != nil {
+			return "", nil, "", errors.Wrapf(err, "data key cannot be unwrapped with key %s", key.ID)
+		}
+		return parts[0], dataKey, parts[2], nil
+	}
+
+	return "", nil, "", errors.Errorf("encryption key %s is not configured", parts[0])
+}
+
+// Encrypts with AES-256-GCM, the nonce is prepended to the base64 encoded result
+func seal(key []byte, plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func open(key []byte, sealed string) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// Dotted paths of the values the values.schema.json of a chart marks as secret, with "secret": true, "writeOnly":
+// true or "format": "password"
+func SchemaSecrets(schema []byte) map[string]bool {
+	secrets := map[string]bool{}
+
+	if len(schema) == 0 {
+		return secrets
+	}
+
+	var root map[string]interface{}
+
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return secrets
+	}
+
+	var walk func(node map[string]interface{}, path string)
+
+	walk = func(node map[string]interface{}, path string) {
+		properties, _ := node["properties"].(map[string]interface{})
+		for name, raw := range properties {
+			property, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			child := name
+			if path != "" {
+				child = path + "." + name
+			}
+			if property["secret"] == true || property["writeOnly"] == true || property["format"] == "password" {
+				secrets[child] = true
+			}
+			walk(property, child)
+		}
+	}
+
+	walk(root, "")
+
+	return secrets
+}
+
+// Copy of values with the secrets redacted, by their key or by their path among the schema secrets
+func RedactValues(values map[string]interface{}, secrets map[string]bool) map[string]interface{} {
+	return redactPath(values, "", secrets).(map[string]interface{})
+}
+
+func redactPath(value interface{}, path string, secrets map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			if sensitiveKey.MatchString(key) || secrets[child] {
+				redacted[key] = Redacted
+				continue
+			}
+			redacted[key] = redactPath(inner, child, secrets)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, inner := range v {
+			redacted[i] = redactPath(inner, path, secrets)
+		}
+		return redacted
+	}
+	return value
+}
+
+// Reads the values referenced from kubernetes secrets in the namespace of the release into the values. Keys without
+// a path have to hold a values yaml, which is merged in first so that inline vars override it. Keys with a path are
+// set as strings at that path last. The paths, and the top-level keys of the values yaml, are added to the secrets
+// so that they are redacted like the schema secrets.
+func ResolveSecretValues(actionConfig *action.Configuration, namespace string, refs []models.SecretValue, values map[string]interface{}, secrets map[string]bool) (map[string]interface{}, error) {
+	if len(refs) == 0 {
+		return values, nil
+	}
+
+	clientset, err := actionConfig.KubernetesClientSet()
+
+	if err != nil {
+		return nil, err
+	}
+
+	base := map[string]interface{}{}
+	set := []string{}
+
+	for _, ref := range refs {
+		if ref.Secret == "" || ref.Key == "" {
+			return nil, errors.Wrap(ErrSecretValue, "a secret and a key are required")
+		}
+
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), ref.Secret, metav1.GetOptions{})
+
+		if err != nil {
+			return nil, errors.Wrapf(ErrSecretValue, "secret %s in namespace %s: %s", ref.Secret, namespace, err)
+		}
+
+		data, ok := secret.Data[ref.Key]
+
+		if !ok {
+			return nil, errors.Wrapf(ErrSecretValue, "secret %s in namespace %s has no key %s", ref.Secret, namespace, ref.Key)
+		}
+
+		if ref.Path == "" {
+			file, err := chartutil.ReadValues(data)
+			if err != nil {
+				return nil, errors.Wrapf(ErrSecretValue, "key %s of secret %s is not a values yaml", ref.Key, ref.Secret)
+			}
+			for key := range file {
+				secrets[key] = true
+			}
+			base = chartutil.CoalesceTables(file.AsMap(), base)
+			continue
+		}
+
+		// Values walked for redaction do not carry list indices in their path
+		secrets[ref.Path], secrets[listIndex.ReplaceAllString(ref.Path, "")] = true, true
+
+		escaped := strings.NewReplacer(`\`, `\\`, `,`, `\,`).Replace(string(data))
+		set = append(set, ref.Path+"="+escaped)
+	}
+
+	merged := chartutil.CoalesceTables(values, base)
+
+	for _, value := range set {
+		if err := strvals.ParseIntoString(value, merged); err != nil {
+			return nil, errors.Wrapf(ErrSecretValue, "invalid path in %s", strings.SplitN(value, "=", 2)[0])
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"encoding/base64"
+	"github.com/mainak90/helmer/config"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testEncryptionKey(id string, fill byte) string {
+	key := make([]byte, 32)
+
+	for i := range key {
+		key[i] = fill
+	}
+
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func TestSealValuesRotation(t *testing.T) {
+	defer func(keys []string) { config.Get().Auth.EncryptionKeys = keys }(config.Get().Auth.EncryptionKeys)
+
+	k1, k2 := testEncryptionKey("k1", 1), testEncryptionKey("k2", 2)
+	vars := []string{"image.tag=1.0,db.password=hunter2", `hosts={a\,b}`}
+
+	config.Get().Auth.EncryptionKeys = []string{k1}
+
+	sealed, err := SealValues(vars, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(sealed, sealedPrefix+"k1:") || strings.Contains(sealed, "hunter2") {
+		t.Fatalf("values were not sealed with k1: %s", sealed)
+	}
+
+	// k2 encrypts once it comes first, k1 is kept to open what it sealed
+	config.Get().Auth.EncryptionKeys = []string{k2, k1}
+
+	keys, err := EncryptionKeys()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened, err := OpenValues(sealed); err != nil || opened != strings.Join(vars, ",") {
+		t.Fatalf("got %q and error %v opening values sealed with k1", opened, err)
+	}
+
+	resealed, changed, err := resealValues(sealed, keys[0])
+
+	if err != nil || !changed || !strings.HasPrefix(resealed, sealedPrefix+"k2:") {
+		t.Fatalf("got %q, changed %t and error %v rotating to k2", resealed, changed, err)
+	}
+
+	if again, changed, err := resealValues(resealed, keys[0]); err != nil || changed || again != resealed {
+		t.Errorf("values sealed with k2 were sealed again: changed %t, error %v", changed, err)
+	}
+
+	plain, changed, err := resealValues("replicas=2", keys[0])
+
+	if err != nil || !changed || !strings.HasPrefix(plain, sealedPrefix+"k2:") {
+		t.Errorf("got %q, changed %t and error %v sealing plain values", plain, changed, err)
+	}
+
+	// Once k1 is dropped the rotated values still open, the ones left on k1 no longer do
+	config.Get().Auth.EncryptionKeys = []string{k2}
+
+	if opened, err := OpenValues(resealed); err != nil || opened != strings.Join(vars, ",") {
+		t.Errorf("got %q and error %v opening rotated values", opened, err)
+	}
+
+	if _, err := OpenValues(sealed); err == nil {
+		t.Error("values sealed with a dropped key were opened")
+	}
+
+	if opened, err := OpenValues("replicas=2"); err != nil || opened != "replicas=2" {
+		t.Errorf("got %q and error %v opening values which were never sealed", opened, err)
+	}
+
+	if _, err := OpenValues(sealedPrefix + "k2:garbage"); err == nil {
+		t.Error("malformed sealed values were opened")
+	}
+}
+
+func TestSealValuesWithoutKeys(t *testing.T) {
+	defer func(keys []string) { config.Get().Auth.EncryptionKeys = keys }(config.Get().Auth.EncryptionKeys)
+
+	config.Get().Auth.EncryptionKeys = []string{}
+
+	sealed, err := SealValues([]string{"db.password=hunter2,db.user=app,tls.key=abc,image.tag=1"}, map[string]bool{"db.user": true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "db.password=" + Redacted + ",db.user=" + Redacted + ",tls.key=" + Redacted + ",image.tag=1"; sealed != want {
+		t.Errorf("got %q, want %q", sealed, want)
+	}
+}
+
+func TestRedactValues(t *testing.T) {
+	values := map[string]interface{}{
+		"image": map[string]interface{}{"tag": "1.0"},
+		"db":    map[string]interface{}{"user": "app", "password": "hunter2"},
+		// Top-level key of a values yaml read from a kubernetes secret
+		"external": map[string]interface{}{"host": "db.example.com"},
+		"hosts":    []interface{}{map[string]interface{}{"name": "a", "apiKey": "k"}, map[string]interface{}{"name": "b", "login": "x"}},
+	}
+
+	secrets := map[string]bool{"db.user": true, "external": true, "hosts.login": true}
+
+	want := map[string]interface{}{
+		"image":    map[string]interface{}{"tag": "1.0"},
+		"db":       map[string]interface{}{"user": Redacted, "password": Redacted},
+		"external": Redacted,
+		"hosts":    []interface{}{map[string]interface{}{"name": "a", "apiKey": Redacted}, map[string]interface{}{"name": "b", "login": Redacted}},
+	}
+
+	if got := RedactValues(values, secrets); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if values["db"].(map[string]interface{})["password"] != "hunter2" {
+		t.Error("the values were redacted in place")
+	}
+}
+
+func TestSchemaSecrets(t *testing.T) {
+	schema := []byte(`{"properties": {
+		"db": {"properties": {"user": {"type": "string"}, "pass": {"type": "string", "format": "password"}}},
+		"apiToken": {"type": "string", "writeOnly": true},
+		"license": {"type": "string", "secret": true}
+	}}`)
+
+	want := map[string]bool{"db.pass": true, "apiToken": true, "license": true}
+
+	if got := SchemaSecrets(schema); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := SchemaSecrets([]byte("{invalid")); len(got) != 0 {
+		t.Errorf("got secrets %v from an invalid schema", got)
+	}
+}
+
+func TestStoredValues(t *testing.T) {
+	defer func(keys []string) { config.Get().Auth.EncryptionKeys = keys }(config.Get().Auth.EncryptionKeys)
+
+	vars := []string{"image.tag=1.0,db.password=hunter2"}
+
+	config.Get().Auth.EncryptionKeys = []string{testEncryptionKey("k1", 1)}
+
+	sealed, err := SealValues(vars, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values, err := StoredValues(sealed, nil); err != nil || !reflect.DeepEqual(values, vars) {
+		t.Errorf("got %v and error %v for sealed values, want %v", values, err, vars)
+	}
+
+	config.Get().Auth.EncryptionKeys = nil
+
+	redacted, err := SealValues(vars, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := StoredValues(redacted, []string{"image.tag=2.0"}); errors.Cause(err) != ErrValuesRedacted {
+		t.Errorf("got error %v for redacted values, want ErrValuesRedacted", err)
+	}
+
+	if _, err := StoredValues(redacted, []string{"image.tag=2.0,db.password=hunter3"}); err != nil {
+		t.Errorf("got error %v for redacted values sent again", err)
+	}
+
+	if _, err := StoredValues("db.password=<redacted>,db.password=hunter3", nil); err != nil {
+		t.Errorf("got error %v for a redacted value set again later", err)
+	}
+
+	if values, err := StoredValues("image.tag=1.0", nil); err != nil || !reflect.DeepEqual(values, []string{"image.tag=1.0"}) {
+		t.Errorf("got %v and error %v for plain values", values, err)
+	}
+
+	if values, err := StoredValues("", nil); err != nil || len(values) != 0 {
+		t.Errorf("got %v and error %v for no values", values, err)
+	}
+}